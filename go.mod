@@ -2,7 +2,10 @@ module gbenson.net/monero-node
 
 go 1.21.4
 
-require github.com/docker/docker v24.0.7+incompatible
+require (
+	github.com/docker/docker v24.0.7+incompatible
+	github.com/google/gopacket v1.1.19
+)
 
 require (
 	github.com/Microsoft/go-winio v0.6.1 // indirect
@@ -11,7 +14,6 @@ require (
 	github.com/docker/go-connections v0.4.0 // indirect
 	github.com/docker/go-units v0.5.0 // indirect
 	github.com/gogo/protobuf v1.3.2 // indirect
-	github.com/google/gopacket v1.1.19 // indirect
 	github.com/moby/term v0.5.0 // indirect
 	github.com/morikuni/aec v1.0.0 // indirect
 	github.com/opencontainers/go-digest v1.0.0 // indirect
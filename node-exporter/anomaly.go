@@ -0,0 +1,256 @@
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+const (
+	DefaultAnomalyFileSize = 16 << 20
+	DefaultAnomalyFileAge  = time.Hour
+	DefaultAnomalyMaxSize  = 256 << 20
+)
+
+const (
+	anomalyFilePrefix = "anomalies-"
+	anomalyFileSuffix = ".pcapng"
+	anomalyTimeFormat = "20060102T150405.000000000Z"
+)
+
+// An AnomalyWriter saves packets the exporter couldn't explain to
+// pcapng files in Dir, starting a new file whenever the current one
+// reaches MaxFileSize or MaxFileAge, and deleting the oldest files
+// to keep their total size within MaxSize.  Files may overrun their
+// limit by the size of one packet.  It must only be used
+// from the packet handling goroutine.
+type AnomalyWriter struct {
+	Dir         string
+	MaxFileSize int64
+	MaxFileAge  time.Duration
+	MaxSize     int64
+
+	// Interfaces describes the devices packets are captured on,
+	// indexed by their InterfaceIndex.  The exporter sets one per
+	// capture.  Packets from interfaces not listed are written as
+	// if from the first.
+	Interfaces []pcapgo.NgInterface
+
+	file    *os.File
+	writer  *pcapgo.NgWriter
+	size    int64
+	created time.Time
+}
+
+func NewAnomalyWriter(dir string) (*AnomalyWriter, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	return &AnomalyWriter{
+		Dir:         dir,
+		MaxFileSize: DefaultAnomalyFileSize,
+		MaxFileAge:  DefaultAnomalyFileAge,
+		MaxSize:     DefaultAnomalyMaxSize,
+	}, nil
+}
+
+// WritePacket appends packet to the current file, rotating first if
+// necessary.
+func (a *AnomalyWriter) WritePacket(packet Packet) error {
+	if a.needsRotate(time.Now()) {
+		if err := a.rotate(); err != nil {
+			return err
+		}
+	}
+
+	data := packet.Data()
+	ci := packet.Metadata().CaptureInfo
+	if ci.CaptureLength != len(data) {
+		// Packets not read from a capture have no metadata.
+		ci.CaptureLength = len(data)
+		ci.Length = len(data)
+	}
+	if ci.Timestamp.IsZero() {
+		ci.Timestamp = time.Now()
+	}
+	if ci.InterfaceIndex < 0 || ci.InterfaceIndex >= len(a.interfaces()) {
+		ci.InterfaceIndex = 0
+	}
+
+	if err := a.writer.WritePacket(ci, data); err != nil {
+		return err
+	}
+	// Anomalies are rare, and files are more useful complete.
+	return a.writer.Flush()
+}
+
+func (a *AnomalyWriter) needsRotate(now time.Time) bool {
+	if a.writer == nil {
+		return true
+	}
+	if a.MaxFileSize > 0 && a.size >= a.MaxFileSize {
+		return true
+	}
+	return a.MaxFileAge > 0 && now.Sub(a.created) >= a.MaxFileAge
+}
+
+// rotate closes the current file, if any, and starts a new one.
+func (a *AnomalyWriter) rotate() error {
+	if err := a.Close(); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	name := anomalyFilePrefix + now.Format(anomalyTimeFormat) +
+		anomalyFileSuffix
+	file, err := os.OpenFile(filepath.Join(a.Dir, name),
+		os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return err
+	}
+
+	a.file = file
+	a.size = 0
+	a.created = now
+
+	interfaces := a.interfaces()
+	writer, err := pcapgo.NewNgWriterInterface(sizeCounter{file, &a.size},
+		interfaces[0], pcapgo.DefaultNgWriterOptions)
+	if err != nil {
+		a.Close()
+		return err
+	}
+	for _, intf := range interfaces[1:] {
+		if _, err := writer.AddInterface(intf); err != nil {
+			a.Close()
+			return err
+		}
+	}
+	a.writer = writer
+	log.Println("writing anomalies to:", file.Name())
+
+	return a.prune(name)
+}
+
+// interfaces returns Interfaces, or a single Ethernet interface if
+// it's empty.
+func (a *AnomalyWriter) interfaces() []pcapgo.NgInterface {
+	if len(a.Interfaces) != 0 {
+		return a.Interfaces
+	}
+	intf := pcapgo.DefaultNgInterface
+	intf.LinkType = layers.LinkTypeEthernet
+	return []pcapgo.NgInterface{intf}
+}
+
+// prune deletes the oldest files until the total size of all but
+// current is within MaxSize less MaxFileSize, so the current file
+// can grow to MaxFileSize without the total exceeding MaxSize.
+func (a *AnomalyWriter) prune(current string) error {
+	if a.MaxSize <= 0 {
+		return nil
+	}
+
+	entries, err := os.ReadDir(a.Dir)
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	sizes := make(map[string]int64)
+	var total int64
+	for _, entry := range entries {
+		name := entry.Name()
+		if name == current ||
+			!strings.HasPrefix(name, anomalyFilePrefix) ||
+			!strings.HasSuffix(name, anomalyFileSuffix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		names = append(names, name)
+		sizes[name] = info.Size()
+		total += info.Size()
+	}
+	sort.Strings(names) // oldest first
+
+	limit := a.MaxSize - a.MaxFileSize
+	for _, name := range names {
+		if total <= limit {
+			break
+		}
+		if err := os.Remove(filepath.Join(a.Dir, name)); err != nil {
+			return err
+		}
+		log.Printf("%s: deleted", name)
+		total -= sizes[name]
+	}
+	return nil
+}
+
+// Close flushes and closes the current file.
+func (a *AnomalyWriter) Close() error {
+	if a.file == nil {
+		return nil
+	}
+
+	var err error
+	if a.writer != nil {
+		err = a.writer.Flush()
+	}
+	if cerr := a.file.Close(); err == nil {
+		err = cerr
+	}
+
+	a.file = nil
+	a.writer = nil
+	return err
+}
+
+// sizeCounter counts the bytes written to the underlying writer.
+type sizeCounter struct {
+	w    io.Writer
+	size *int64
+}
+
+func (c sizeCounter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	*c.size += int64(n)
+	return n, err
+}
+
+// captureAnomaly saves packet if anomaly capture is enabled.  Anomaly
+// capture is disabled after the first error.
+func (e *Exporter) captureAnomaly(packet Packet) {
+	if e.Anomalies == nil {
+		return
+	}
+
+	err := e.Anomalies.WritePacket(packet)
+	if err == nil {
+		return
+	}
+	log.Println("warning:", err)
+	log.Println("warning: anomaly capture disabled")
+
+	if err := e.Anomalies.Close(); err != nil {
+		log.Println("warning:", err)
+	}
+	e.Anomalies = nil
+}
+
+// String describes the limits a is configured with.
+func (a *AnomalyWriter) String() string {
+	return fmt.Sprintf("%s (%d bytes or %v per file, %d bytes total)",
+		a.Dir, a.MaxFileSize, a.MaxFileAge, a.MaxSize)
+}
@@ -0,0 +1,119 @@
+package exporter
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+)
+
+func TestAnomalyWriterRotatesAndPrunes(t *testing.T) {
+	quietLogs(t)
+
+	a, err := NewAnomalyWriter(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.MaxFileSize = 1 // one packet per file
+	a.MaxSize = 1024
+
+	packet := newTCPPacket(t, "172.18.0.99", 40000, "172.18.0.3", 18080)
+	for i := 0; i < 50; i++ {
+		if err := a.WritePacket(packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := filepath.Glob(filepath.Join(a.Dir, "anomalies-*.pcapng"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(names) < 2 || len(names) >= 50 {
+		t.Errorf("got %d files, want rotation and pruning", len(names))
+	}
+
+	// The newest file may overrun MaxFileSize by one packet.
+	var total, largest int64
+	for _, name := range names {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		total += info.Size()
+		largest = max(largest, info.Size())
+	}
+	if total > a.MaxSize+largest {
+		t.Errorf("got %d bytes of anomalies, want at most %d",
+			total, a.MaxSize+largest)
+	}
+
+	f, err := os.Open(names[len(names)-1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, _, err := r.ReadPacketData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != len(packet.Data()) {
+		t.Errorf("got %d byte packet, want %d", len(data), len(packet.Data()))
+	}
+}
+
+func TestAnomalyWriterRecordsEachInterface(t *testing.T) {
+	quietLogs(t)
+
+	a, err := NewAnomalyWriter(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"br-test", "eth0"} {
+		intf := pcapgo.DefaultNgInterface
+		intf.Name = name
+		intf.LinkType = layers.LinkTypeEthernet
+		a.Interfaces = append(a.Interfaces, intf)
+	}
+
+	packet := newTCPPacket(t, "203.0.113.7", 40000, "198.51.100.1", 37889)
+	packet.Metadata().InterfaceIndex = 1
+	if err := a.WritePacket(packet); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	names, err := filepath.Glob(filepath.Join(a.Dir, "anomalies-*.pcapng"))
+	if err != nil || len(names) != 1 {
+		t.Fatalf("got files %v, %v, want one", names, err)
+	}
+	f, err := os.Open(names[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewNgReader(f, pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ci, err := r.ReadPacketData()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ci.InterfaceIndex != 1 {
+		t.Errorf("got interface %d, want 1", ci.InterfaceIndex)
+	}
+	if intf, err := r.Interface(1); err != nil || intf.Name != "eth0" {
+		t.Errorf("got interface %+v, %v, want eth0", intf, err)
+	}
+}
@@ -0,0 +1,165 @@
+package exporter
+
+import (
+	"log"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"github.com/google/gopacket/pcapgo"
+)
+
+// A capture is one source of packets: a live capture on a network
+// device, a capture file being replayed, or a PacketSource supplied
+// by the caller.
+type capture struct {
+	device string // empty unless capturing live
+	bridge bool   // device is a Docker bridge
+	handle *pcap.Handle
+	source PacketSource
+}
+
+// openCaptures opens every packet source the exporter is configured
+// to read from.
+func (e *Exporter) openCaptures(ctx Context) ([]PacketSource, error) {
+	switch {
+	case e.PacketSource != nil:
+		e.captures = []*capture{{source: e.PacketSource}}
+
+	case e.PacketFile != "":
+		c, err := e.openOffline()
+		if err != nil {
+			return nil, err
+		}
+		e.captures = []*capture{c}
+
+	default:
+		devices, err := e.networkDevices(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, device := range devices {
+			c, err := e.openLive(device)
+			if err != nil {
+				e.closeHandles()
+				return nil, err
+			}
+			e.captures = append(e.captures, c)
+		}
+	}
+
+	sources := make([]PacketSource, len(e.captures))
+	for i, c := range e.captures {
+		sources[i] = c.source
+	}
+	return sources, nil
+}
+
+func (e *Exporter) openLive(device string) (*capture, error) {
+	snaplen := e.SnapLen
+	if snaplen <= 0 {
+		snaplen = DefaultSnapLen
+	}
+
+	handle, err := pcap.OpenLive(device, int32(snaplen), e.Promiscuous,
+		captureTimeout)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("listening on:", device)
+
+	filter := e.BPFFilter
+	if filter == "" {
+		filter = DefaultBPFFilter
+	}
+	if err := handle.SetBPFFilter(filter); err != nil {
+		log.Println("warning:", err)
+	}
+
+	return &capture{
+		device: device,
+		bridge: e.isBridgeDevice(device),
+		handle: handle,
+		source: gopacket.NewPacketSource(handle, handle.LinkType()),
+	}, nil
+}
+
+// networkDevices returns the devices to capture on, which default to
+// the bridge of each Docker network.
+func (e *Exporter) networkDevices(ctx Context) ([]string, error) {
+	if len(e.NetworkDevices) != 0 {
+		return e.NetworkDevices, nil
+	}
+
+	networks, err := e.dockerNetworks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, network := range networks {
+		e.NetworkDevices = append(e.NetworkDevices, "br-"+network.ID[:12])
+	}
+	return e.NetworkDevices, nil
+}
+
+// isBridgeDevice reports whether device is the bridge of a Docker
+// network, as opposed to an uplink its traffic is routed over.
+func (e *Exporter) isBridgeDevice(device string) bool {
+	if strings.HasPrefix(device, "br-") || strings.HasPrefix(device, "docker") {
+		return true
+	}
+	for _, network := range e.DockerNetworks {
+		if network.Options["com.docker.network.bridge.name"] == device {
+			return true
+		}
+	}
+	return false
+}
+
+// bridges reports which captures are of Docker bridges, by interface
+// index.
+func (e *Exporter) bridges() []bool {
+	bridges := make([]bool, len(e.captures))
+	for i, c := range e.captures {
+		bridges[i] = c.bridge
+	}
+	return bridges
+}
+
+// interfaceName returns the name of the device packets with the
+// given interface index were captured on.
+func (e *Exporter) interfaceName(index int) string {
+	if index < 0 || index >= len(e.captures) {
+		return ""
+	}
+	return e.captures[index].device
+}
+
+// ngInterfaces describes each capture for pcapng files, in the order
+// of the InterfaceIndex stamped on their packets.
+func (e *Exporter) ngInterfaces() []pcapgo.NgInterface {
+	interfaces := make([]pcapgo.NgInterface, len(e.captures))
+	for i, c := range e.captures {
+		intf := pcapgo.DefaultNgInterface
+		intf.Name = c.device
+		intf.LinkType = layers.LinkTypeEthernet
+		if c.handle != nil {
+			intf.LinkType = c.handle.LinkType()
+		}
+		interfaces[i] = intf
+	}
+	return interfaces
+}
+
+// handles returns the pcap handles of every capture.
+func (e *Exporter) handles() []*pcap.Handle {
+	var handles []*pcap.Handle
+	for _, c := range e.captures {
+		if c.handle != nil {
+			handles = append(handles, c.handle)
+		}
+	}
+	return handles
+}
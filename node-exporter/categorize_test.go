@@ -0,0 +1,173 @@
+package exporter
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/network"
+	"github.com/google/gopacket/layers"
+)
+
+func newFakeDockerExporter(t *testing.T) (*Exporter, *fakeDocker) {
+	quietLogs(t)
+
+	docker := newFakeDocker("test")
+	docker.connect("c1", "p2pool", "172.18.0.3")
+	docker.connect("c2", "monerod", "172.18.0.4")
+	docker.connect("c3", "xmrig", "172.18.0.5")
+	docker.roles["c3"] = Host(LocalMiner).String()
+
+	e := &Exporter{
+		DockerClient:     docker,
+		DockerNetworkIDs: []string{"test"},
+	}
+	return e, docker
+}
+
+func TestCategorize(t *testing.T) {
+	const stratum = DefaultStratumPort
+
+	tests := []struct {
+		name     string
+		ip       string
+		port     layers.TCPPort
+		peerPort layers.TCPPort
+		want     Host
+		wantErr  bool
+	}{
+		{"global IPv4", "203.0.113.1", 18080, 40000, ExternalHost, false},
+		{"global IPv6", "2001:db8:18::3", 18080, 40000, P2PoolNode, false},
+		{"global IPv6 elsewhere", "2001:db8::1", 18080, 40000, ExternalHost, false},
+		{"loopback", "127.0.0.1", 18080, 40000, NilHost, true},
+		{"multicast", "224.0.0.1", 18080, 40000, NilHost, true},
+		{"by name", "172.18.0.3", 18080, 40000, P2PoolNode, false},
+		{"by name again", "172.18.0.3", 37889, 40000, P2PoolNode, false},
+		{"other name", "172.18.0.4", 18080, 40000, MoneroNode, false},
+		{"unknown in IPv6 subnet", "2001:db8:18::99", 40000, 18080, UnknownHost, true},
+		{"by role", "172.18.0.5", 40000, stratum, LocalMiner, false},
+		{"miner off stratum", "172.18.0.5", 40000, 80, LocalMiner, true},
+		{"unknown private", "172.18.0.99", 40000, 18080, UnknownHost, true},
+		{"unknown ULA", "fd00::99", 40000, 18080, UnknownHost, true},
+		{"inferred miner", "10.1.2.3", 40000, stratum, LocalMiner, false},
+		{"inferred miner cached", "10.1.2.3", 40001, stratum, LocalMiner, false},
+	}
+
+	e, docker := newFakeDockerExporter(t)
+	docker.network.IPAM.Config = []network.IPAMConfig{{Subnet: "2001:db8:18::/64"}}
+	endpoint := docker.network.Containers["c1"]
+	endpoint.IPv6Address = "2001:db8:18::3/64"
+	docker.network.Containers["c1"] = endpoint
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := e.Categorize(context.Background(),
+				net.ParseIP(tt.ip), tt.port, tt.peerPort)
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// Everything after the first lookup was a cache hit.
+	if n := docker.inspectionCount(); n != 1 {
+		t.Errorf("network inspected %d times, want 1", n)
+	}
+}
+
+func TestCategorizeAfterRescan(t *testing.T) {
+	e, docker := newFakeDockerExporter(t)
+	ctx := context.Background()
+	ip := net.ParseIP("172.18.0.6")
+
+	if got, _ := e.Categorize(ctx, ip, 18080, 40000); got != UnknownHost {
+		t.Fatalf("got %v before connect, want %v", got, UnknownHost)
+	}
+
+	docker.connect("c4", "monerod", ip.String())
+	docker.disconnect("c1")
+	if err := e.refreshNetwork(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, err := e.Categorize(ctx, ip, 18080, 40000); got != MoneroNode ||
+		err != nil {
+		t.Errorf("got %v, %v after connect, want %v", got, err, MoneroNode)
+	}
+
+	// The disconnected container's address is forgotten.
+	got, _ := e.Categorize(ctx, net.ParseIP("172.18.0.3"), 18080, 40000)
+	if got != UnknownHost {
+		t.Errorf("got %v after disconnect, want %v", got, UnknownHost)
+	}
+}
+
+func TestDockerEventsTriggerRescan(t *testing.T) {
+	e, docker := newFakeDockerExporter(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e.watchDocker(ctx)
+	}()
+
+	connect := events.Message{
+		Type:   events.NetworkEventType,
+		Action: "connect",
+		Actor: events.Actor{
+			ID:         docker.network.ID,
+			Attributes: map[string]string{"container": "c4"},
+		},
+	}
+	docker.connect("c4", "monerod", "172.18.0.6")
+	docker.events <- connect
+	// The unbuffered channel ensures the first event was taken
+	// before the second is sent, and so was handled before any
+	// of the second's handling is observed.
+	docker.events <- connect
+	cancel()
+	<-done
+
+	e.hostsMu.Lock()
+	defer e.hostsMu.Unlock()
+
+	key, _ := knownHostsKey(net.ParseIP("172.18.0.6"))
+	if got := e.knownHosts[key]; got != MoneroNode {
+		t.Errorf("got %v, want %v", got, MoneroNode)
+	}
+}
+
+func TestContainerEventsUpdateRoleOnly(t *testing.T) {
+	e, docker := newFakeDockerExporter(t)
+	ctx := context.Background()
+	if err := e.refreshNetwork(ctx); err != nil {
+		t.Fatal(err)
+	}
+	inspections := docker.inspectionCount()
+
+	err := e.applyEvent(ctx, events.Message{
+		Type:   events.ContainerEventType,
+		Action: "start",
+		Actor: events.Actor{
+			ID:         "c2",
+			Attributes: map[string]string{RoleLabel: "p2pool"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := docker.inspectionCount(); n != inspections {
+		t.Errorf("network inspected %d times for a container event",
+			n-inspections)
+	}
+
+	got, err := e.Categorize(ctx, net.ParseIP("172.18.0.4"), 18080, 40000)
+	if got != P2PoolNode || err != nil {
+		t.Errorf("got %v, %v after relabelling, want %v", got, err, P2PoolNode)
+	}
+}
@@ -2,9 +2,14 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"strings"
+	"syscall"
 
 	"gbenson.net/monero-node/node-exporter"
 )
@@ -12,19 +17,53 @@ import (
 func main() {
 	log.SetFlags(log.Lshortfile)
 
-	if len(os.Args) > 2 {
-		fmt.Println("usage: node-exporter [DOCKER_NETWORK]")
-		os.Exit(2)
+	if len(os.Args) > 1 && os.Args[1] == "query" {
+		query(os.Args[2:])
+		return
 	}
 
-	e := &exporter.Exporter{}
-	if len(os.Args) == 2 {
-		e.DockerNetworkID = os.Args[1]
+	flag.Usage = usage
+	exporter.DefaultConfig().RegisterFlags(flag.CommandLine)
+	flag.Parse()
+
+	if flag.NArg() != 0 {
+		flag.Set("network", strings.Join(flag.Args(), ","))
+	}
+
+	config, err := exporter.LoadConfig(flag.CommandLine)
+	if err != nil {
+		fatal(err)
+	}
+	if err := config.Validate(); err != nil {
+		fatal(err)
 	}
 
-	err := e.Run(context.Background())
+	e, err := exporter.NewExporter(config)
 	if err != nil {
-		fmt.Println("error:", err)
-		os.Exit(1)
+		fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(),
+		os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	err = e.Run(ctx)
+	if err != nil && !errors.Is(err, context.Canceled) {
+		fatal(err)
 	}
 }
+
+func fatal(err error) {
+	fmt.Println("error:", err)
+	os.Exit(1)
+}
+
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintln(out, "usage: node-exporter [OPTION]... [DOCKER_NETWORK]...")
+	fmt.Fprintln(out, "   or: node-exporter query [OPTION]...")
+	flag.PrintDefaults()
+	fmt.Fprintf(out, "\nEvery option may also be set in the environment"+
+		" (e.g. %sSNAPLEN)\nor in the JSON file given by -config.\n",
+		exporter.EnvPrefix)
+}
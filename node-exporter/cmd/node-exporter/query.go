@@ -0,0 +1,115 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"gbenson.net/monero-node/node-exporter"
+)
+
+// query implements "node-exporter query", which prints the traffic
+// between each pair of hosts recorded in the store.
+func query(args []string) {
+	fs := flag.NewFlagSet("query", flag.ExitOnError)
+	fs.Usage = func() {
+		out := fs.Output()
+		fmt.Fprintln(out, "usage: node-exporter query [OPTION]...")
+		fs.PrintDefaults()
+		fmt.Fprintln(out, "\nTimes may be RFC 3339, YYYY-MM-DD, or durations"+
+			" before now\nsuch as 36h or 7d.")
+	}
+
+	defaults := exporter.DefaultConfig()
+	fs.StringVar(&defaults.ConfigFile, "config", "",
+		"read settings from JSON `FILE`")
+	defaults.RegisterStoreFlags(fs)
+	from := fs.String("from", "24h", "start of the time range")
+	to := fs.String("to", "", "end of the time range (default now)")
+	rollup := fs.String("rollup", "",
+		"use `NAME` rollup buckets (default: the finest that reaches -from)")
+	fs.Parse(args)
+
+	if fs.NArg() != 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	config, err := exporter.LoadConfig(fs)
+	if err != nil {
+		fatal(err)
+	}
+	if config.StoreDir == "" {
+		fatal(fmt.Errorf("no store (use -store DIR)"))
+	}
+	if _, err := os.Stat(config.StoreDir); err != nil {
+		fatal(err)
+	}
+	store, err := exporter.OpenStore(config)
+	if err != nil {
+		fatal(err)
+	}
+
+	now := time.Now()
+	start, err := parseTime(*from, now)
+	if err != nil {
+		fatal(err)
+	}
+	end := now
+	if *to != "" {
+		if end, err = parseTime(*to, now); err != nil {
+			fatal(err)
+		}
+	}
+
+	if !end.After(start) {
+		fatal(fmt.Errorf("%s: empty range", *to))
+	}
+	r := store.QueryRollup(start, end, now)
+	if *rollup != "" {
+		if r = store.Rollup(*rollup); r == nil {
+			fatal(fmt.Errorf("%s: no such rollup", *rollup))
+		}
+	}
+
+	totals, err := store.Query(r, start, end)
+	if err != nil {
+		fatal(err)
+	}
+
+	fmt.Printf("# %s to %s, %s buckets\n",
+		start.Format(time.RFC3339), end.Format(time.RFC3339), r.Name)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SRC\tDST\tBYTES\tPACKETS\tOPENS\tRETRANSMITS\t")
+	for _, t := range totals {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%d\t\n", t.Src, t.Dst,
+			t.Bytes, t.Packets, t.Opens, t.Retransmits)
+	}
+	w.Flush()
+}
+
+// parseTime parses s as an absolute time, or as a duration before now.
+func parseTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, time.Local); err == nil {
+		return t, nil
+	}
+
+	if days, found := strings.CutSuffix(s, "d"); found {
+		if n, err := strconv.Atoi(days); err == nil {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(s); err == nil {
+		return now.Add(-d), nil
+	}
+
+	return time.Time{}, fmt.Errorf("%s: invalid time", s)
+}
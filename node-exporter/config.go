@@ -0,0 +1,575 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+// EnvPrefix prefixes the environment variable equivalent of each
+// command-line flag, so -snaplen may be given as NODE_EXPORTER_SNAPLEN.
+const EnvPrefix = "NODE_EXPORTER_"
+
+const maxSnapLen = 262144
+
+// Config holds everything needed to construct an Exporter.  Each
+// setting may come from a command-line flag, an environment variable
+// or a JSON config file, in that order of precedence.
+type Config struct {
+	ConfigFile string
+
+	Device      string
+	Network     string
+	NetworkFile string
+	HostsFile   string
+	BPFFilter   string
+	SnapLen     int
+	Promiscuous bool
+
+	PacketFile       string
+	ReplayTimestamps bool
+
+	ExportInterval time.Duration
+	StratumPort    uint
+
+	MetricsAddr   string
+	JSONFile      string
+	PostURL       string
+	PostTokenFile string
+	OTLPURL       string
+	OTLPProvider  string
+
+	InfluxURL       string
+	InfluxTokenFile string
+	StatsDAddr      string
+	StatsDTags      string
+
+	AnomalyDir      string
+	AnomalyFileSize int64
+	AnomalyFileAge  time.Duration
+	AnomalyMaxSize  int64
+
+	StoreDir       string
+	StoreRetention map[string]time.Duration
+
+	StateFile     string
+	StateInterval time.Duration
+
+	TopTalkers int
+
+	ProcRoot string
+
+	PolicyFile string
+}
+
+func DefaultConfig() *Config {
+	return &Config{
+		BPFFilter:      DefaultBPFFilter,
+		SnapLen:        DefaultSnapLen,
+		Promiscuous:    true,
+		ExportInterval: DefaultExportFreq,
+		StratumPort:    DefaultStratumPort,
+		MetricsAddr:    DefaultMetricsAddr,
+
+		AnomalyFileSize: DefaultAnomalyFileSize,
+		AnomalyFileAge:  DefaultAnomalyFileAge,
+		AnomalyMaxSize:  DefaultAnomalyMaxSize,
+
+		StoreRetention: defaultStoreRetention(),
+
+		StateInterval: DefaultStateInterval,
+
+		TopTalkers: DefaultTopTalkers,
+	}
+}
+
+func defaultStoreRetention() map[string]time.Duration {
+	retention := make(map[string]time.Duration)
+	for _, r := range DefaultRollups() {
+		retention[r.Name] = r.Retention
+	}
+	return retention
+}
+
+// RegisterFlags defines a flag in fs for every setting in c.
+func (c *Config) RegisterFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.ConfigFile, "config", c.ConfigFile,
+		"read settings from JSON `FILE`")
+
+	fs.StringVar(&c.Device, "device", c.Device,
+		"capture on comma-separated network `DEVICES` (default: each Docker network's bridge)")
+	fs.StringVar(&c.Network, "network", c.Network,
+		"categorize hosts using comma-separated Docker `NETWORKS` (default \""+
+			DefaultNetworkID+"\")")
+	fs.StringVar(&c.NetworkFile, "network-file", c.NetworkFile,
+		"read Docker network details from `FILE` (\"docker network inspect\" output)")
+	fs.StringVar(&c.HostsFile, "hosts", c.HostsFile,
+		"read container to host category mappings from JSON `FILE`")
+	fs.StringVar(&c.BPFFilter, "filter", c.BPFFilter,
+		"capture only packets matching BPF `EXPRESSION`")
+	fs.IntVar(&c.SnapLen, "snaplen", c.SnapLen,
+		"capture at most `BYTES` of each packet")
+	fs.BoolVar(&c.Promiscuous, "promisc", c.Promiscuous,
+		"capture in promiscuous mode")
+
+	fs.StringVar(&c.PacketFile, "read", c.PacketFile,
+		"replay packets from pcap or pcapng `FILE` instead of capturing")
+	fs.BoolVar(&c.ReplayTimestamps, "replay-timestamps", c.ReplayTimestamps,
+		"use captured packet timestamps for export windows when replaying")
+
+	fs.DurationVar(&c.ExportInterval, "interval", c.ExportInterval,
+		"export counts every `DURATION`")
+	fs.UintVar(&c.StratumPort, "stratum-port", c.StratumPort,
+		"p2pool stratum `PORT`")
+
+	fs.StringVar(&c.MetricsAddr, "listen", c.MetricsAddr,
+		"serve Prometheus metrics on `ADDR` (empty to disable)")
+	fs.StringVar(&c.JSONFile, "json", c.JSONFile,
+		"append each export window as JSON to `FILE` (\"-\" for stdout)")
+	fs.StringVar(&c.PostURL, "post", c.PostURL,
+		"POST each export window as JSON to `URL`")
+	fs.StringVar(&c.PostTokenFile, "post-token-file", c.PostTokenFile,
+		"read the bearer token for -post from `FILE`")
+	fs.StringVar(&c.OTLPURL, "otlp", c.OTLPURL,
+		"push each export window to the OTLP/HTTP collector at `URL`"+
+			" (e.g. http://localhost:4318)")
+	fs.StringVar(&c.OTLPProvider, "otlp-provider", c.OTLPProvider,
+		"report cloud provider `NAME` to the OTLP collector")
+	fs.StringVar(&c.InfluxURL, "influx", c.InfluxURL,
+		"write each export window as InfluxDB line protocol to `URL`"+
+			" (an HTTP write endpoint, or udp://HOST:PORT)")
+	fs.StringVar(&c.InfluxTokenFile, "influx-token-file", c.InfluxTokenFile,
+		"read the API token (USER:PASSWORD for InfluxDB 1.x) for -influx from `FILE`")
+	fs.StringVar(&c.StatsDAddr, "statsd", c.StatsDAddr,
+		"send each export window as StatsD counters to UDP `ADDR`")
+	fs.StringVar(&c.StatsDTags, "statsd-tags", c.StatsDTags,
+		"tag StatsD counters in `FORMAT` \""+StatsDTagsDogStatsD+
+			"\" or \""+StatsDTagsInflux+"\"")
+
+	fs.StringVar(&c.AnomalyDir, "anomalies", c.AnomalyDir,
+		"save unexplained packets as pcapng files in `DIR`")
+	fs.Int64Var(&c.AnomalyFileSize, "anomaly-file-size", c.AnomalyFileSize,
+		"start a new anomaly file after `BYTES`")
+	fs.DurationVar(&c.AnomalyFileAge, "anomaly-file-age", c.AnomalyFileAge,
+		"start a new anomaly file after `DURATION`")
+	fs.Int64Var(&c.AnomalyMaxSize, "anomaly-max-size", c.AnomalyMaxSize,
+		"delete the oldest anomaly files to keep their total within `BYTES`")
+
+	c.RegisterStoreFlags(fs)
+
+	fs.StringVar(&c.StateFile, "state", c.StateFile,
+		"save cumulative totals to `FILE`, and restore them on start")
+	fs.DurationVar(&c.StateInterval, "state-interval", c.StateInterval,
+		"save cumulative totals every `DURATION`")
+
+	fs.IntVar(&c.TopTalkers, "top-talkers", c.TopTalkers,
+		"track the heaviest `N` external peers of each host (0 to disable)")
+
+	fs.StringVar(&c.ProcRoot, "procfs", c.ProcRoot,
+		"attribute traffic outside Docker to processes using procfs at `DIR`"+
+			" (e.g. "+DefaultProcRoot+")")
+
+	fs.StringVar(&c.PolicyFile, "policy", c.PolicyFile,
+		"read allowed flows from JSON `FILE` (default: local miners may only talk to P2Pool)")
+}
+
+// RegisterStoreFlags defines a flag in fs for each of c's Store
+// settings, for commands that only read the store.
+func (c *Config) RegisterStoreFlags(fs *flag.FlagSet) {
+	fs.StringVar(&c.StoreDir, "store", c.StoreDir,
+		"keep a history of traffic in `DIR`")
+	if c.StoreRetention == nil {
+		c.StoreRetention = make(map[string]time.Duration)
+	}
+	for _, r := range DefaultRollups() {
+		fs.Var(retentionValue{c.StoreRetention, r.Name},
+			"store-retention-"+r.Name,
+			"keep "+r.Name+" rollups for `DURATION` (0 keeps forever)")
+	}
+}
+
+// retentionValue is a flag.Value setting one rollup's retention.
+type retentionValue struct {
+	m    map[string]time.Duration
+	name string
+}
+
+func (v retentionValue) String() string {
+	if v.m == nil {
+		return "0s"
+	}
+	return v.m[v.name].String()
+}
+
+func (v retentionValue) Set(s string) error {
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	v.m[v.name] = d
+	return nil
+}
+
+// LoadConfig builds a Config from defaults, then the config file,
+// then the environment, then any flags set in fs.  Flags in fs that
+// RegisterFlags doesn't define are ignored.
+func LoadConfig(fs *flag.FlagSet) (*Config, error) {
+	c := DefaultConfig()
+	layer := flag.NewFlagSet(fs.Name(), flag.ContinueOnError)
+	layer.SetOutput(io.Discard)
+	c.RegisterFlags(layer)
+
+	configFile := os.Getenv(envName("config"))
+	if f := fs.Lookup("config"); f != nil && f.Value.String() != "" {
+		configFile = f.Value.String()
+	}
+	if configFile != "" {
+		if err := loadConfigFile(layer, configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []error
+	layer.VisitAll(func(f *flag.Flag) {
+		value, found := os.LookupEnv(envName(f.Name))
+		if !found {
+			return
+		}
+		if err := layer.Set(f.Name, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", envName(f.Name), err))
+		}
+	})
+
+	fs.Visit(func(f *flag.Flag) {
+		if layer.Lookup(f.Name) == nil {
+			return // not a Config setting
+		}
+		if err := layer.Set(f.Name, f.Value.String()); err != nil {
+			errs = append(errs, fmt.Errorf("-%s: %w", f.Name, err))
+		}
+	})
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// loadConfigFile reads a JSON object whose keys are flag names.
+func loadConfigFile(fs *flag.FlagSet, filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	// Numbers are kept as written, so large sizes and durations
+	// in nanoseconds survive the trip through the flag parsers.
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var settings map[string]any
+	if err := dec.Decode(&settings); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	var errs []error
+	for name, value := range settings {
+		if name == "config" || fs.Lookup(name) == nil {
+			errs = append(errs, fmt.Errorf("%s: unknown setting %q",
+				filename, name))
+			continue
+		}
+		if err := fs.Set(name, fmt.Sprint(value)); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s: %w",
+				filename, name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func envName(flagName string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// Validate checks c for errors that would otherwise only show up
+// once capture had started.
+func (c *Config) Validate() error {
+	var errs []error
+	add := func(format string, a ...any) {
+		errs = append(errs, fmt.Errorf(format, a...))
+	}
+
+	if c.SnapLen < 1 || c.SnapLen > maxSnapLen {
+		add("snaplen: %d: must be between 1 and %d", c.SnapLen, maxSnapLen)
+	}
+	if c.ExportInterval <= 0 {
+		add("interval: %v: must be positive", c.ExportInterval)
+	}
+	if c.StratumPort < 1 || c.StratumPort > 65535 {
+		add("stratum-port: %d: invalid port", c.StratumPort)
+	}
+
+	if c.BPFFilter != "" {
+		_, err := pcap.CompileBPFFilter(layers.LinkTypeEthernet,
+			c.SnapLen, c.BPFFilter)
+		if err != nil {
+			add("filter: %q: %w", c.BPFFilter, err)
+		}
+	}
+
+	if c.ReplayTimestamps && c.PacketFile == "" {
+		add("replay-timestamps: requires -read")
+	}
+	for name, filename := range map[string]string{
+		"read":              c.PacketFile,
+		"network-file":      c.NetworkFile,
+		"hosts":             c.HostsFile,
+		"post-token-file":   c.PostTokenFile,
+		"policy":            c.PolicyFile,
+		"influx-token-file": c.InfluxTokenFile,
+	} {
+		if filename == "" {
+			continue
+		}
+		if _, err := os.Stat(filename); err != nil {
+			add("%s: %w", name, err)
+		}
+	}
+
+	if c.MetricsAddr != "" {
+		if _, _, err := net.SplitHostPort(c.MetricsAddr); err != nil {
+			add("listen: %w", err)
+		}
+	}
+	for name, rawURL := range map[string]string{
+		"post": c.PostURL,
+		"otlp": c.OTLPURL,
+	} {
+		if rawURL == "" {
+			continue
+		}
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			add("%s: %w", name, err)
+		} else if u.Scheme != "http" && u.Scheme != "https" {
+			add("%s: %s: not an HTTP URL", name, rawURL)
+		}
+	}
+	if c.PostTokenFile != "" && c.PostURL == "" {
+		add("post-token-file: requires -post")
+	}
+	if c.OTLPProvider != "" && c.OTLPURL == "" {
+		add("otlp-provider: requires -otlp")
+	}
+
+	if c.InfluxURL != "" {
+		u, err := url.Parse(c.InfluxURL)
+		if err != nil {
+			add("influx: %w", err)
+		} else if u.Scheme != "http" && u.Scheme != "https" &&
+			u.Scheme != "udp" {
+			add("influx: %s: not an HTTP or UDP URL", c.InfluxURL)
+		} else if u.Scheme == "udp" && c.InfluxTokenFile != "" {
+			add("influx-token-file: requires an HTTP -influx URL")
+		}
+	}
+	if c.InfluxTokenFile != "" && c.InfluxURL == "" {
+		add("influx-token-file: requires -influx")
+	}
+	if c.StatsDAddr != "" {
+		if _, _, err := net.SplitHostPort(c.StatsDAddr); err != nil {
+			add("statsd: %w", err)
+		}
+	}
+	switch c.StatsDTags {
+	case "", StatsDTagsDogStatsD, StatsDTagsInflux:
+	default:
+		add("statsd-tags: %s: unknown format", c.StatsDTags)
+	}
+
+	if c.AnomalyDir != "" {
+		if c.AnomalyFileSize <= 0 {
+			add("anomaly-file-size: %d: must be positive",
+				c.AnomalyFileSize)
+		}
+		if c.AnomalyFileAge <= 0 {
+			add("anomaly-file-age: %v: must be positive",
+				c.AnomalyFileAge)
+		}
+		if c.AnomalyMaxSize < c.AnomalyFileSize {
+			add("anomaly-max-size: %d: must be at least"+
+				" -anomaly-file-size", c.AnomalyMaxSize)
+		}
+	}
+
+	if c.ProcRoot != "" {
+		_, err := os.Stat(filepath.Join(c.ProcRoot, "net", "tcp"))
+		if err != nil {
+			add("procfs: %w", err)
+		}
+	}
+	if c.TopTalkers < 0 {
+		add("top-talkers: %d: must not be negative", c.TopTalkers)
+	}
+	if c.StateFile != "" && c.StateInterval <= 0 {
+		add("state-interval: %v: must be positive", c.StateInterval)
+	}
+
+	for name, d := range c.StoreRetention {
+		if d < 0 {
+			add("store-retention-%s: %v: must not be negative", name, d)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// OpenStore returns the Store in c.StoreDir, with the retention
+// c specifies.
+func OpenStore(c *Config) (*Store, error) {
+	store, err := NewStore(c.StoreDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range store.Rollups {
+		if d, found := c.StoreRetention[r.Name]; found {
+			r.Retention = d
+		}
+	}
+	return store, nil
+}
+
+// NewExporter returns an Exporter configured by c, which should
+// have been validated.
+func NewExporter(c *Config) (*Exporter, error) {
+	e := &Exporter{
+		DockerNetworkIDs: splitList(c.Network),
+		NetworkDevices:   splitList(c.Device),
+		SnapLen:          c.SnapLen,
+		Promiscuous:      c.Promiscuous,
+		BPFFilter:        c.BPFFilter,
+		PacketFile:       c.PacketFile,
+		ReplayTimestamps: c.ReplayTimestamps,
+		ExportFrequency:  c.ExportInterval,
+		StratumPort:      layers.TCPPort(c.StratumPort),
+		MetricsAddr:      c.MetricsAddr,
+		StateFile:        c.StateFile,
+		StateInterval:    c.StateInterval,
+		TopTalkerCount:   c.TopTalkers,
+		PolicyFile:       c.PolicyFile,
+	}
+	if c.ProcRoot != "" {
+		e.Processes = NewProcessAttributor(c.ProcRoot)
+	}
+
+	if c.HostsFile != "" {
+		if err := LoadHostConfig(c.HostsFile); err != nil {
+			return nil, err
+		}
+	}
+
+	e.Policy = DefaultPolicy()
+
+	if c.AnomalyDir != "" {
+		anomalies, err := NewAnomalyWriter(c.AnomalyDir)
+		if err != nil {
+			return nil, err
+		}
+		anomalies.MaxFileSize = c.AnomalyFileSize
+		anomalies.MaxFileAge = c.AnomalyFileAge
+		anomalies.MaxSize = c.AnomalyMaxSize
+		e.Anomalies = anomalies
+	}
+
+	if c.NetworkFile != "" {
+		networks, err := LoadDockerNetworks(c.NetworkFile)
+		if err != nil {
+			return nil, err
+		}
+		e.DockerNetworks = networks
+	}
+
+	var sinks []Sink
+	if c.JSONFile != "" {
+		sink, err := OpenJSONSink(c.JSONFile)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if c.PostURL != "" {
+		sink := &HTTPSink{Receiver: APIEndpoint{URL: c.PostURL}}
+		if c.PostTokenFile != "" {
+			token, err := os.ReadFile(c.PostTokenFile)
+			if err != nil {
+				return nil, err
+			}
+			sink.Receiver.AccessToken = strings.TrimSpace(string(token))
+		}
+		sinks = append(sinks, sink)
+	}
+	if c.OTLPURL != "" {
+		sink, err := NewOTLPSink(c.OTLPURL, c.OTLPProvider)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if c.InfluxURL != "" {
+		sink, err := NewInfluxSink(c.InfluxURL)
+		if err != nil {
+			return nil, err
+		}
+		if c.InfluxTokenFile != "" {
+			token, err := os.ReadFile(c.InfluxTokenFile)
+			if err != nil {
+				return nil, err
+			}
+			sink.Receiver.AccessToken = strings.TrimSpace(string(token))
+		}
+		sinks = append(sinks, sink)
+	}
+	if c.StatsDAddr != "" {
+		sink, err := NewStatsDSink(c.StatsDAddr, c.StatsDTags)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+	if c.StoreDir != "" {
+		store, err := OpenStore(c)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, store)
+	}
+	if len(sinks) != 0 {
+		sink := NewFanoutSink(sinks...)
+		sink.Blocking = e.PacketFile != ""
+		e.Sink = sink
+	}
+
+	return e, nil
+}
+
+// splitList splits a comma-separated list, dropping empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
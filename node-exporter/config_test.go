@@ -0,0 +1,194 @@
+package exporter
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadConfigFileKeepsLargeNumbers(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(filename,
+		[]byte(`{"anomaly-max-size": 9007199254740993, "snaplen": 1500}`),
+		0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	DefaultConfig().RegisterFlags(fs)
+	if err := fs.Parse([]string{"-config", filename}); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadConfig(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.AnomalyMaxSize != 9007199254740993 {
+		t.Errorf("got anomaly-max-size %d", c.AnomalyMaxSize)
+	}
+	if c.SnapLen != 1500 {
+		t.Errorf("got snaplen %d", c.SnapLen)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	tests := []struct {
+		name            string
+		file, env, flag string // snaplen from each source, if any
+		want            int
+	}{
+		{"default", "", "", "", DefaultSnapLen},
+		{"file", "1000", "", "", 1000},
+		{"env", "", "2000", "", 2000},
+		{"flag", "", "", "3000", 3000},
+		{"env over file", "1000", "2000", "", 2000},
+		{"flag over file", "1000", "", "3000", 3000},
+		{"flag over env", "", "2000", "3000", 3000},
+		{"flag over env over file", "1000", "2000", "3000", 3000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args []string
+			if tt.file != "" {
+				filename := filepath.Join(t.TempDir(), "config.json")
+				data := `{"snaplen": ` + tt.file + `}`
+				if err := os.WriteFile(filename, []byte(data), 0644); err != nil {
+					t.Fatal(err)
+				}
+				args = append(args, "-config", filename)
+			}
+			if tt.env != "" {
+				t.Setenv(envName("snaplen"), tt.env)
+			}
+			if tt.flag != "" {
+				args = append(args, "-snaplen", tt.flag)
+			}
+
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			DefaultConfig().RegisterFlags(fs)
+			if err := fs.Parse(args); err != nil {
+				t.Fatal(err)
+			}
+			c, err := LoadConfig(fs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.SnapLen != tt.want {
+				t.Errorf("got snaplen %d, want %d", c.SnapLen, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileFromEnv(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "config.json")
+	err := os.WriteFile(filename, []byte(`{"interval": "30s"}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(envName("config"), filename)
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	DefaultConfig().RegisterFlags(fs)
+	c, err := LoadConfig(fs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.ExportInterval != 30*time.Second {
+		t.Errorf("got interval %v, want 30s", c.ExportInterval)
+	}
+}
+
+func TestLoadConfigRejects(t *testing.T) {
+	tests := []struct {
+		name      string
+		file, env string
+	}{
+		{"unknown setting", `{"snaplenn": 1500}`, ""},
+		{"config in file", `{"config": "other.json"}`, ""},
+		{"bad file value", `{"snaplen": "big"}`, ""},
+		{"bad JSON", `{"snaplen": }`, ""},
+		{"bad env value", "", "big"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var args []string
+			if tt.file != "" {
+				filename := filepath.Join(t.TempDir(), "config.json")
+				if err := os.WriteFile(filename, []byte(tt.file), 0644); err != nil {
+					t.Fatal(err)
+				}
+				args = append(args, "-config", filename)
+			}
+			if tt.env != "" {
+				t.Setenv(envName("snaplen"), tt.env)
+			}
+
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			DefaultConfig().RegisterFlags(fs)
+			if err := fs.Parse(args); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := LoadConfig(fs); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing")
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   string // in the error, or "" for none
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"snaplen", func(c *Config) { c.SnapLen = 0 }, "snaplen"},
+		{"interval", func(c *Config) { c.ExportInterval = 0 }, "interval"},
+		{"stratum port", func(c *Config) { c.StratumPort = 70000 }, "stratum-port"},
+		{"replay without read", func(c *Config) { c.ReplayTimestamps = true }, "replay-timestamps"},
+		{"missing file", func(c *Config) { c.HostsFile = missing }, "hosts"},
+		{"listen", func(c *Config) { c.MetricsAddr = "9100" }, "listen"},
+		{"post scheme", func(c *Config) { c.PostURL = "ftp://example.com/" }, "post"},
+		{"token without post", func(c *Config) { c.PostTokenFile = os.DevNull }, "requires -post"},
+		{"provider without otlp", func(c *Config) { c.OTLPProvider = "x" }, "requires -otlp"},
+		{"influx scheme", func(c *Config) { c.InfluxURL = "tcp://localhost:8086" }, "influx"},
+		{"influx token over UDP", func(c *Config) {
+			c.InfluxURL = "udp://localhost:8089"
+			c.InfluxTokenFile = os.DevNull
+		}, "influx-token-file"},
+		{"statsd", func(c *Config) { c.StatsDAddr = "localhost" }, "statsd"},
+		{"statsd tags", func(c *Config) { c.StatsDTags = "graphite" }, "statsd-tags"},
+		{"anomaly sizes", func(c *Config) {
+			c.AnomalyDir = t.TempDir()
+			c.AnomalyMaxSize = c.AnomalyFileSize - 1
+		}, "anomaly-max-size"},
+		{"procfs", func(c *Config) { c.ProcRoot = missing }, "procfs"},
+		{"top talkers", func(c *Config) { c.TopTalkers = -1 }, "top-talkers"},
+		{"state interval", func(c *Config) {
+			c.StateFile = missing
+			c.StateInterval = 0
+		}, "state-interval"},
+		{"retention", func(c *Config) { c.StoreRetention["1m"] = -time.Hour }, "store-retention-1m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := DefaultConfig()
+			c.BPFFilter = "" // compiling one needs libpcap
+			tt.modify(c)
+			err := c.Validate()
+			switch {
+			case tt.want == "" && err != nil:
+				t.Errorf("got %v, want no error", err)
+			case tt.want != "" && (err == nil || !strings.Contains(err.Error(), tt.want)):
+				t.Errorf("got %v, want an error about %s", err, tt.want)
+			}
+		})
+	}
+}
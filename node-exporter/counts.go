@@ -0,0 +1,113 @@
+package exporter
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Counts are the statistics accumulated for each Flow.
+type Counts struct {
+	Bytes       uint64 `json:"bytes"`
+	Packets     uint64 `json:"packets"`
+	Opens       uint64 `json:"opens"`       // SYN without ACK
+	Closes      uint64 `json:"closes"`      // FIN
+	Resets      uint64 `json:"resets"`      // RST
+	Retransmits uint64 `json:"retransmits"` // estimated
+}
+
+func (c *Counts) Add(other *Counts) {
+	c.Bytes += other.Bytes
+	c.Packets += other.Packets
+	c.Opens += other.Opens
+	c.Closes += other.Closes
+	c.Resets += other.Resets
+	c.Retransmits += other.Retransmits
+}
+
+// countPacket accounts for one TCP packet of size bytes.
+func (c *Counts) countPacket(tcp *layers.TCP, size int, retransmit bool) {
+	c.Bytes += uint64(size)
+	c.Packets++
+
+	if tcp.SYN && !tcp.ACK {
+		c.Opens++
+	}
+	if tcp.FIN {
+		c.Closes++
+	}
+	if tcp.RST {
+		c.Resets++
+	}
+	if retransmit {
+		c.Retransmits++
+	}
+}
+
+// Connections are tracked until this many have been seen, at which
+// point the least recently active half are forgotten.
+const maxTrackedConns = 1 << 16
+
+type connKey struct {
+	net, tcp gopacket.Flow
+}
+
+// seqTracker estimates retransmissions by remembering the highest
+// sequence number sent in each direction of each connection.  Any
+// segment that doesn't extend beyond it is a retransmission.
+type seqTracker struct {
+	cur, prev map[connKey]uint32
+}
+
+func (t *seqTracker) isRetransmit(packet Packet, tcp *layers.TCP) bool {
+	length := uint32(len(tcp.Payload))
+	if tcp.SYN || tcp.FIN {
+		length++
+	}
+	if length == 0 {
+		return false // pure ACK
+	}
+
+	netLayer := packet.NetworkLayer()
+	if netLayer == nil {
+		return false
+	}
+	key := connKey{netLayer.NetworkFlow(), tcp.TransportFlow()}
+	end := tcp.Seq + length
+
+	next, found := t.lookup(key)
+	if tcp.RST || tcp.FIN {
+		t.forget(key)
+	} else if !found || seqAfter(end, next) {
+		t.remember(key, end)
+	}
+
+	return found && !seqAfter(end, next)
+}
+
+func (t *seqTracker) lookup(key connKey) (uint32, bool) {
+	if next, found := t.cur[key]; found {
+		return next, true
+	}
+	next, found := t.prev[key]
+	return next, found
+}
+
+func (t *seqTracker) remember(key connKey, next uint32) {
+	if t.cur == nil || len(t.cur) >= maxTrackedConns/2 {
+		t.prev = t.cur
+		t.cur = make(map[connKey]uint32)
+	}
+	t.cur[key] = next
+	delete(t.prev, key)
+}
+
+func (t *seqTracker) forget(key connKey) {
+	delete(t.cur, key)
+	delete(t.prev, key)
+}
+
+// seqAfter reports whether sequence number a comes after b,
+// allowing for wraparound.
+func seqAfter(a, b uint32) bool {
+	return int32(a-b) > 0
+}
@@ -0,0 +1,175 @@
+package exporter
+
+import (
+	"encoding/binary"
+	"hash/maphash"
+	"net/netip"
+	"slices"
+
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	// Each generation of the duplicate filter holds this many
+	// packets, so a copy is recognized for between one and two
+	// generations' worth of packets after the first.
+	dedupGeneration = 8192
+
+	// Only this much of each payload is hashed.
+	dedupPayloadPrefix = 64
+)
+
+// A dedupFilter drops the second copy of packets captured on more
+// than one interface, such as on both a Docker bridge and the uplink
+// it's routed over.  Copies can differ in their addresses, ports and
+// checksums when NAT is involved, so packets are matched on the rest
+// of their TCP header and the start of their payload.  The first copy
+// seen is kept, along with any further copies seen on the same
+// interface, since those are retransmissions.
+//
+// Only the bridge copy of a container's traffic can be attributed,
+// so once a packet has been seen on both a bridge and an uplink, the
+// uplink connection it was NATed to is remembered, and from then on
+// its packets are dropped whichever copy arrives first.  Uplink
+// traffic that's never seen on a bridge, such as that of host
+// networked containers, is always kept.
+type dedupFilter struct {
+	seed     maphash.Seed
+	bridges  []bool                // by interface index
+	current  map[uint64]dedupEntry // by packet hash
+	previous map[uint64]dedupEntry
+	nated    map[connEnds]bool // uplink connections
+	natedOld map[connEnds]bool
+}
+
+type dedupEntry struct {
+	iface int
+	conn  connEnds
+}
+
+// A connEnds identifies a TCP connection by its endpoints, in the
+// same order whichever direction a packet is going in.
+type connEnds struct {
+	a, b netip.AddrPort
+}
+
+// newDedupFilter returns a filter for packets captured on interfaces
+// that are Docker bridges or not as bridges says.
+func newDedupFilter(bridges []bool) *dedupFilter {
+	return &dedupFilter{
+		seed:    maphash.MakeSeed(),
+		bridges: bridges,
+		current: make(map[uint64]dedupEntry),
+		nated:   make(map[connEnds]bool),
+	}
+}
+
+// isBridge reports whether iface is a Docker bridge.
+func (d *dedupFilter) isBridge(iface int) bool {
+	return iface >= 0 && iface < len(d.bridges) && d.bridges[iface]
+}
+
+// isUplink reports whether iface carries traffic NATed from a
+// Docker bridge that's also being captured.
+func (d *dedupFilter) isUplink(iface int) bool {
+	return iface >= 0 && iface < len(d.bridges) && !d.bridges[iface] &&
+		slices.Contains(d.bridges, true)
+}
+
+// isDuplicate reports whether packet is a copy of one already seen on
+// another interface, or is on an uplink connection already seen to
+// be NATed from a bridge.
+func (d *dedupFilter) isDuplicate(packet Packet) bool {
+	layer := packet.Layer(layers.LayerTypeTCP)
+	if layer == nil {
+		return false
+	}
+	tcp, ok := layer.(*layers.TCP)
+	if !ok {
+		return false
+	}
+
+	iface := packet.Metadata().InterfaceIndex
+	conn, connOK := packetConn(packet, tcp)
+	if connOK && d.isUplink(iface) && (d.nated[conn] || d.natedOld[conn]) {
+		return true
+	}
+
+	key := d.hash(tcp)
+	seen, found := d.current[key]
+	if !found {
+		seen, found = d.previous[key]
+	}
+	if found {
+		if seen.iface == iface {
+			return false
+		}
+		switch {
+		case d.isUplink(iface) && d.isBridge(seen.iface) && connOK:
+			d.addNATed(conn)
+		case d.isUplink(seen.iface) && d.isBridge(iface) && seen.conn.a.IsValid():
+			d.addNATed(seen.conn)
+		}
+		return true
+	}
+
+	if len(d.current) >= dedupGeneration {
+		d.previous = d.current
+		d.current = make(map[uint64]dedupEntry, dedupGeneration)
+	}
+	d.current[key] = dedupEntry{iface, conn}
+	return false
+}
+
+func (d *dedupFilter) addNATed(conn connEnds) {
+	if len(d.nated) >= dedupGeneration {
+		d.natedOld = d.nated
+		d.nated = make(map[connEnds]bool, dedupGeneration)
+	}
+	d.nated[conn] = true
+}
+
+// packetConn returns the connection packet belongs to.
+func packetConn(packet Packet, tcp *layers.TCP) (connEnds, bool) {
+	srcIP, dstIP, err := networkAddrs(packet)
+	if err != nil || srcIP == nil {
+		return connEnds{}, false
+	}
+	src, ok1 := addrPort(srcIP, tcp.SrcPort)
+	dst, ok2 := addrPort(dstIP, tcp.DstPort)
+	if !ok1 || !ok2 {
+		return connEnds{}, false
+	}
+	if c := dst.Addr().Compare(src.Addr()); c < 0 || c == 0 && dst.Port() < src.Port() {
+		src, dst = dst, src
+	}
+	return connEnds{src, dst}, true
+}
+
+func (d *dedupFilter) hash(tcp *layers.TCP) uint64 {
+	var h maphash.Hash
+	h.SetSeed(d.seed)
+
+	var header [14]byte
+	binary.BigEndian.PutUint32(header[0:], tcp.Seq)
+	binary.BigEndian.PutUint32(header[4:], tcp.Ack)
+	// The flags are in the low 12 bits of the header's 13th and
+	// 14th bytes, after the data offset.
+	binary.BigEndian.PutUint16(header[8:], uint16(tcp.Contents[12]&0x0f)<<8|
+		uint16(tcp.Contents[13]))
+	binary.BigEndian.PutUint16(header[10:], tcp.Window)
+	binary.BigEndian.PutUint16(header[12:], uint16(len(tcp.Payload)))
+	h.Write(header[:])
+
+	if len(tcp.Contents) > 20 {
+		h.Write(tcp.Contents[20:]) // options
+	}
+
+	payload := tcp.Payload
+	if len(payload) > dedupPayloadPrefix {
+		payload = payload[:dedupPayloadPrefix]
+	}
+	h.Write(payload)
+
+	return h.Sum64()
+}
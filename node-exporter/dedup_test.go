@@ -0,0 +1,164 @@
+package exporter
+
+import (
+	"context"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// withSeq returns a copy of packet with its TCP sequence number set
+// to seq, captured on interface iface.
+func withSeq(packet Packet, seq uint32, iface int) Packet {
+	data := append([]byte(nil), packet.Data()...)
+	tcp := packet.Layer(layers.LayerTypeTCP).(*layers.TCP)
+	offset := len(data) - len(tcp.Contents) - len(tcp.Payload)
+	binary.BigEndian.PutUint32(data[offset+4:], seq)
+
+	dup := gopacket.NewPacket(data, layers.LinkTypeEthernet,
+		gopacket.Default)
+	dup.Metadata().InterfaceIndex = iface
+	return dup
+}
+
+func TestDedupFilter(t *testing.T) {
+	bridge := newTCPPacket(t, "172.18.0.3", 40000, "203.0.113.7", 18080)
+	uplink := newTCPPacket(t, "198.51.100.1", 51234, "203.0.113.7", 18080)
+
+	d := newDedupFilter(nil)
+	if d.isDuplicate(withSeq(bridge, 1, 0)) {
+		t.Error("first copy reported as duplicate")
+	}
+	if !d.isDuplicate(withSeq(uplink, 1, 1)) {
+		t.Error("NATed copy on another interface not reported")
+	}
+	if d.isDuplicate(withSeq(bridge, 1, 0)) {
+		t.Error("retransmission reported as duplicate")
+	}
+	if d.isDuplicate(withSeq(uplink, 2, 1)) {
+		t.Error("different packet reported as duplicate")
+	}
+}
+
+func TestHandlePacketsDedupsSources(t *testing.T) {
+	quietLogs(t)
+
+	sink := &collectingSink{}
+	e := newTestExporter(sink)
+	e.ExportFrequency = time.Hour
+
+	packet := newTCPPacket(t, "172.18.0.3", 40000, "203.0.113.7", 18080)
+	var bridge, uplink fakePacketSource
+	for seq := uint32(1); seq <= 3; seq++ {
+		bridge.packets = append(bridge.packets, withSeq(packet, seq, 0))
+		uplink.packets = append(uplink.packets, withSeq(packet, seq, 0))
+	}
+	uplink.packets = append(uplink.packets, withSeq(packet, 4, 0))
+
+	err := e.handlePackets(context.Background(), &bridge, &uplink)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	totals := sink.totals()
+	var packets uint64
+	for _, counts := range totals.Flows {
+		packets += counts.Packets
+	}
+	if packets != 4 {
+		t.Errorf("got %d packets, want 4", packets)
+	}
+	if got := totals.Health.PacketsDuplicate; got != 3 {
+		t.Errorf("got %d duplicates, want 3", got)
+	}
+}
+
+// newBridgeExporter returns an exporter capturing on a Docker bridge,
+// interface 0, and the uplink it's NATed to, interface 1.
+func newBridgeExporter(sink Sink) *Exporter {
+	e := newTestExporter(sink)
+	e.captures = []*capture{
+		{device: "br-test", bridge: true},
+		{device: "eth0"},
+	}
+	e.dedup = newDedupFilter(e.bridges())
+	e.Reset()
+	return e
+}
+
+func TestHandlePacketPrefersBridgeCopy(t *testing.T) {
+	quietLogs(t)
+
+	// Inbound NAT traffic reaches the uplink first, addressed to
+	// this host, and is only then forwarded to the container.
+	e := newBridgeExporter(nil)
+	bridgePacket := newTCPPacket(t, "203.0.113.7", 40000, "172.18.0.3", 37889)
+	uplinkPacket := newTCPPacket(t, "203.0.113.7", 40000, "198.51.100.1", 37889)
+	const count = 4
+	for seq := uint32(1); seq <= count; seq++ {
+		for _, packet := range []Packet{
+			withSeq(uplinkPacket, seq, 1),
+			withSeq(bridgePacket, seq, 0),
+		} {
+			if err := e.handlePacket(context.Background(), packet); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// Only the first packet is seen on the uplink before the
+	// connection is known to be NATed.
+	bridge := Flow{
+		HostPair:  PairHosts(ExternalHost, P2PoolNode),
+		Port:      37889,
+		Interface: "br-test",
+	}
+	var uplink uint64
+	for flow, counts := range e.window.Flows {
+		if flow != bridge {
+			uplink += counts.Packets
+		}
+	}
+	if got := e.window.Flows[bridge]; got == nil || got.Packets != count-1 {
+		t.Errorf("got %+v for %+v, want %d packets", got, bridge, count-1)
+	}
+	if uplink != 1 {
+		t.Errorf("got %d packets on the uplink, want 1", uplink)
+	}
+	if got := e.window.Health.PacketsDuplicate; got != count {
+		t.Errorf("got %d duplicates, want %d", got, count)
+	}
+}
+
+func TestHandlePacketKeepsHostTraffic(t *testing.T) {
+	quietLogs(t)
+
+	// A host networked monerod has no bridge copies.
+	e := newBridgeExporter(nil)
+	key, _ := knownHostsKey(net.ParseIP("192.168.1.10"))
+	e.knowHost(key, MoneroNode)
+	packet := newTCPPacket(t, "203.0.113.7", 40000, "192.168.1.10", 18080)
+	const count = 3
+	for seq := uint32(1); seq <= count; seq++ {
+		err := e.handlePacket(context.Background(), withSeq(packet, seq, 1))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := Flow{
+		HostPair:  PairHosts(ExternalHost, MoneroNode),
+		Port:      18080,
+		Interface: "eth0",
+	}
+	if got := e.window.Flows[want]; got == nil || got.Packets != count {
+		t.Errorf("got %+v for %+v, want %d packets", got, want, count)
+	}
+	if got := e.window.Health.PacketsDuplicate; got != 0 {
+		t.Errorf("got %d duplicates, want none", got)
+	}
+}
@@ -0,0 +1,162 @@
+package exporter
+
+import (
+	"log"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+)
+
+const dockerEventsRetryWait = 5 * time.Second
+
+// watchDocker follows the Docker events stream, updating the cached
+// networks and knownHosts whenever containers are started, stopped,
+// connected or disconnected.  Each event updates only the container
+// or network it's about.  It returns when ctx is done.
+func (e *Exporter) watchDocker(ctx Context) {
+	for {
+		err := e.followDockerEvents(ctx)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Println("warning: Docker events:", err)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(dockerEventsRetryWait):
+		}
+	}
+}
+
+func (e *Exporter) followDockerEvents(ctx Context) error {
+	client, err := e.dockerClient(ctx)
+	if err != nil {
+		return err
+	}
+
+	opts := types.EventsOptions{
+		Filters: filters.NewArgs(
+			filters.Arg("type", events.NetworkEventType),
+			filters.Arg("type", events.ContainerEventType),
+			filters.Arg("event", "connect"),
+			filters.Arg("event", "disconnect"),
+			filters.Arg("event", "start"),
+			filters.Arg("event", "die"),
+		),
+	}
+	msgs, errs := client.Events(ctx, opts)
+
+	// Anything could have happened while we weren't subscribed.
+	if err := e.refreshNetwork(ctx); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case err := <-errs:
+			return err
+
+		case msg := <-msgs:
+			if !e.isRelevantEvent(msg) {
+				continue
+			}
+			log.Printf("Docker %s %s: %s", msg.Type, msg.Action,
+				msg.Actor.Attributes["name"])
+
+			if err := e.applyEvent(ctx, msg); err != nil {
+				log.Println("warning:", err)
+			}
+		}
+	}
+}
+
+// applyEvent updates knownHosts for one event.  Network events
+// re-inspect only the network concerned; container events carry the
+// container's labels, so update its role without asking Docker.
+func (e *Exporter) applyEvent(ctx Context, msg events.Message) error {
+	if msg.Type == events.NetworkEventType {
+		client, err := e.dockerClient(ctx)
+		if err != nil {
+			return err
+		}
+		e.dockerRescans.Add(1)
+		opts := types.NetworkInspectOptions{}
+		network, err := client.NetworkInspect(ctx, msg.Actor.ID, opts)
+		if err != nil {
+			return err
+		}
+
+		e.hostsMu.Lock()
+		defer e.hostsMu.Unlock()
+
+		e.applyNetwork(&network)
+		return nil
+	}
+
+	id := msg.Actor.ID
+	role, labelled := msg.Actor.Attributes[RoleLabel]
+
+	e.hostsMu.Lock()
+	defer e.hostsMu.Unlock()
+
+	if cached, found := e.containerRoles[id]; found == labelled && cached == role {
+		return nil
+	}
+	if !labelled {
+		delete(e.containerRoles, id)
+	} else {
+		if e.containerRoles == nil {
+			e.containerRoles = make(map[string]string)
+		}
+		e.containerRoles[id] = role
+	}
+	for _, network := range e.DockerNetworks {
+		if _, found := network.Containers[id]; found {
+			e.applyNetwork(network)
+		}
+	}
+	return nil
+}
+
+func (e *Exporter) isRelevantEvent(msg events.Message) bool {
+	if msg.Type != events.NetworkEventType {
+		return true
+	}
+
+	e.hostsMu.Lock()
+	defer e.hostsMu.Unlock()
+
+	if len(e.DockerNetworks) == 0 {
+		return true
+	}
+	for _, network := range e.DockerNetworks {
+		if msg.Actor.ID == network.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// refreshNetwork re-inspects the Docker networks and container role
+// labels, and applies any changes to knownHosts.
+func (e *Exporter) refreshNetwork(ctx Context) error {
+	networks, err := e.inspectDockerNetworks(ctx)
+	if err != nil {
+		return err
+	}
+
+	roles, err := e.listContainerRoles(ctx)
+	if err != nil {
+		return err
+	}
+
+	e.hostsMu.Lock()
+	defer e.hostsMu.Unlock()
+
+	e.containerRoles = roles
+	e.applyNetworks(networks)
+	return nil
+}
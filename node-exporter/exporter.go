@@ -2,117 +2,263 @@ package exporter
 
 import (
 	"context"
+	"io"
 	"log"
 	"net"
+	"net/http"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/filters"
 	"github.com/docker/docker/client"
 
-	"github.com/google/gopacket"
 	"github.com/google/gopacket/layers"
 	"github.com/google/gopacket/pcap"
 )
 
 const (
-	DefaultExportFreq = time.Minute
-	DefaultNetworkID  = "monero-node_default"
-	StratumPort       = 3333
+	DefaultExportFreq  = time.Minute
+	DefaultMetricsAddr = "127.0.0.1:9186"
+	DefaultNetworkID   = "monero-node_default"
+	DefaultStratumPort = 3333
+	DefaultSnapLen     = 65535
+	DefaultBPFFilter   = "tcp"
 )
 
-type Exporter struct {
-	DockerClient    *DockerClient
-	DockerNetworkID string
-	DockerNetwork   *DockerNetwork
-	NetworkDevice   string
-	PacketSource    *PacketSource
-	ExportFrequency time.Duration
-
-	knownHosts map[string]Host
+const (
+	captureTimeout    = 250 * time.Millisecond
+	packetQueueLength = 4096
+)
 
-	mu         sync.Mutex
-	byteCounts map[HostPair]int
+type Exporter struct {
+	DockerClient     DockerClient
+	DockerNetworkIDs []string
+	DockerNetworks   []*DockerNetwork
+	NetworkDevices   []string
+	SnapLen          int
+	Promiscuous      bool
+	BPFFilter        string
+	PacketSource     PacketSource
+	PacketFile       string
+	ReplayTimestamps bool
+	ExportFrequency  time.Duration
+	StratumPort      layers.TCPPort
+	MetricsAddr      string
+	Sink             Sink
+	Anomalies        *AnomalyWriter
+	StateFile        string
+	StateInterval    time.Duration
+	TopTalkerCount   int // per local host category
+	Processes        *ProcessAttributor
+	Policy           Policy
+	PolicyFile       string // replaces Policy when the exporter runs
+
+	// OnAlert, if set, is called with each alert the Policy
+	// raises.  It's called from the packet handling goroutine,
+	// so mustn't block.
+	OnAlert func(Alert)
+
+	captures        []*capture
+	ownDockerClient bool
+
+	handleMu      sync.Mutex
+	handlesClosed bool
+	finalStats    *pcap.Stats
+
+	dockerRescans      atomic.Uint64
+	lastExportDuration time.Duration
+
+	offenders offenderTable
+	talkers   talkerTable
+	alerts    alertTable
+
+	lastTopTalkersLog time.Time
+
+	lastCheckpoint time.Time
+
+	hostsMu        sync.Mutex
+	knownHosts     map[string]Host
+	dockerHosts    map[string]string // network ID, by knownHosts key
+	containerRoles map[string]string
+
+	// Owned by the packet handling goroutine.
+	window     *Window
+	seqs       seqTracker
+	stratum    *stratumDecoder
+	levin      *levinDecoder
+	dedup      *dedupFilter
 	lastReset  time.Time
+	replayTime time.Time
+	lastStats  *pcap.Stats
+
+	totalsMu sync.Mutex
+	totals   *Window
 }
 
 func (e *Exporter) Run(ctx Context) error {
 	if ctx == nil {
 		panic("nil context")
 	}
+	defer e.closeDockerClient()
+
+	// Policies may name container roles, so are read once Docker
+	// can be asked what those are.
+	if e.PolicyFile != "" {
+		if err := e.loadPolicy(ctx); err != nil {
+			return err
+		}
+	}
 
-	ps, err := e.packetSource(ctx)
+	sources, err := e.openCaptures(ctx)
 	if err != nil {
 		return err
 	}
 
-	return e.handlePackets(ctx, ps)
-}
+	// Restored flows are matched to the devices just opened.
+	if e.StateFile != "" {
+		if err := e.loadState(); err != nil {
+			log.Println("warning:", err)
+		}
+		defer func() {
+			if err := e.saveState(); err != nil {
+				log.Println("warning:", err)
+			}
+		}()
+	}
 
-func (e *Exporter) packetSource(ctx Context) (*PacketSource, error) {
-	if e.PacketSource != nil {
-		return e.PacketSource, nil
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	// Closing the handles is what unblocks captures in progress.
+	handles := e.handles()
+	if len(handles) != 0 {
+		defer e.closeHandles()
+		stop := context.AfterFunc(ctx, e.closeHandles)
+		defer stop()
 	}
 
-	device, err := e.networkDevice(ctx)
-	if err != nil {
-		return nil, err
+	if e.Anomalies != nil {
+		e.Anomalies.Interfaces = e.ngInterfaces()
+		log.Println("capturing anomalies to:", e.Anomalies)
+		defer func() {
+			if e.Anomalies == nil {
+				return
+			}
+			if err := e.Anomalies.Close(); err != nil {
+				log.Println("warning:", err)
+			}
+		}()
 	}
 
-	handle, err := pcap.OpenLive(device, 65535, true, pcap.BlockForever)
-	if err != nil {
-		return nil, err
+	if c, ok := e.Sink.(io.Closer); ok {
+		defer func() {
+			if err := c.Close(); err != nil {
+				log.Println("warning:", err)
+			}
+		}()
 	}
-	log.Println("listening on:", device)
 
-	if err := handle.SetBPFFilter("tcp"); err != nil {
-		log.Println("warning:", err)
+	if e.MetricsAddr != "" {
+		ln, err := net.Listen("tcp", e.MetricsAddr)
+		if err != nil {
+			return err
+		}
+		log.Println("serving metrics on:", ln.Addr())
+
+		srv := &http.Server{Handler: e.MetricsHandler()}
+		defer srv.Close()
+		go func() {
+			cancel(srv.Serve(ln))
+		}()
 	}
 
-	e.PacketSource = gopacket.NewPacketSource(handle, handle.LinkType())
-	return e.PacketSource, nil
+	if e.PacketFile == "" {
+		if _, err := e.dockerClient(ctx); err != nil {
+			return err
+		}
+		go e.watchDocker(ctx)
+	}
+	if e.Processes != nil {
+		go e.Processes.Run(ctx)
+	}
+
+	return e.handlePackets(ctx, sources...)
 }
 
-func (e *Exporter) networkDevice(ctx Context) (string, error) {
-	if e.NetworkDevice != "" {
-		return e.NetworkDevice, nil
+func (e *Exporter) dockerNetworks(ctx Context) ([]*DockerNetwork, error) {
+	if len(e.DockerNetworks) != 0 {
+		return e.DockerNetworks, nil
 	}
 
-	network, err := e.dockerNetwork(ctx)
+	networks, err := e.inspectDockerNetworks(ctx)
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
-	e.NetworkDevice = "br-" + network.ID[:12]
-	return e.NetworkDevice, nil
+	roles, err := e.listContainerRoles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	e.DockerNetworks = networks
+	e.containerRoles = roles
+	return e.DockerNetworks, nil
 }
 
-func (e *Exporter) dockerNetwork(ctx Context) (*DockerNetwork, error) {
-	if e.DockerNetwork != nil {
-		return e.DockerNetwork, nil
+func (e *Exporter) inspectDockerNetworks(ctx Context) ([]*DockerNetwork, error) {
+	client, err := e.dockerClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	netIDs := e.DockerNetworkIDs
+	if len(netIDs) == 0 {
+		netIDs = []string{DefaultNetworkID}
+	}
+	e.dockerRescans.Add(1)
+
+	networks := make([]*DockerNetwork, 0, len(netIDs))
+	for _, netID := range netIDs {
+		opts := types.NetworkInspectOptions{}
+		network, err := client.NetworkInspect(ctx, netID, opts)
+		if err != nil {
+			return nil, err
+		}
+		networks = append(networks, &network)
 	}
 
+	return networks, nil
+}
+
+// listContainerRoles returns the RoleLabel of every container that
+// has one, indexed by container ID.
+func (e *Exporter) listContainerRoles(ctx Context) (map[string]string, error) {
 	client, err := e.dockerClient(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	netID := e.DockerNetworkID
-	if netID == "" {
-		netID = DefaultNetworkID
+	opts := types.ContainerListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", RoleLabel)),
 	}
-
-	opts := types.NetworkInspectOptions{}
-	network, err := client.NetworkInspect(ctx, netID, opts)
+	containers, err := client.ContainerList(ctx, opts)
 	if err != nil {
 		return nil, err
 	}
 
-	e.DockerNetwork = &network
-	return e.DockerNetwork, nil
+	roles := make(map[string]string, len(containers))
+	for _, container := range containers {
+		roles[container.ID] = container.Labels[RoleLabel]
+	}
+	return roles, nil
 }
 
-func (e *Exporter) dockerClient(ctx Context) (*DockerClient, error) {
+func (e *Exporter) dockerClient(ctx Context) (DockerClient, error) {
 	if e.DockerClient != nil {
 		return e.DockerClient, nil
 	}
@@ -126,106 +272,364 @@ func (e *Exporter) dockerClient(ctx Context) (*DockerClient, error) {
 	}
 
 	e.DockerClient = dc
+	e.ownDockerClient = true
 	return e.DockerClient, nil
 }
 
-func (e *Exporter) handlePackets(ctx Context, ps *PacketSource) error {
+func (e *Exporter) closeDockerClient() {
+	if !e.ownDockerClient {
+		return
+	}
+
+	if err := e.DockerClient.Close(); err != nil {
+		log.Println("warning:", err)
+	}
+	e.DockerClient = nil
+	e.ownDockerClient = false
+}
+
+// handlePackets runs the packet handling loop.  The loop owns the
+// current window and everything that counts into it; the export
+// goroutine takes each finished window by sending the loop a
+// rotateRequest, so per-packet accounting never waits on a lock.
+// Each source is read by its own goroutine, and packets from all of
+// them are handled in the order they arrive.
+func (e *Exporter) handlePackets(ctx Context, sources ...PacketSource) error {
 	e.Reset()
+	if len(sources) > 1 {
+		e.dedup = newDedupFilter(e.bridges())
+	}
 
-	ctx, cancel := context.WithCancelCause(ctx)
+	loopCtx, cancel := context.WithCancelCause(ctx)
 	defer cancel(nil)
+
+	packets := make(chan Packet, packetQueueLength)
+	var readers sync.WaitGroup
+	var readErrMu sync.Mutex
+	var readErr error
+	for i, ps := range sources {
+		readers.Add(1)
+		go func(i int, ps PacketSource) {
+			defer readers.Done()
+			err := readPackets(loopCtx, i, ps, packets)
+			if err == io.EOF {
+				return
+			}
+
+			// The first source to fail stops the others.
+			readErrMu.Lock()
+			if readErr == nil {
+				readErr = err
+			}
+			readErrMu.Unlock()
+			cancel(err)
+		}(i, ps)
+	}
 	go func() {
-		cancel(e.exportMetrics(ctx))
+		readers.Wait()
+		close(packets)
 	}()
 
+	rotations := make(chan rotateRequest)
+	exporterDone := make(chan struct{})
+	if e.ReplayTimestamps {
+		e.lastReset = time.Time{}
+		close(exporterDone)
+	} else {
+		lastReset := e.lastReset
+		go func() {
+			defer close(exporterDone)
+			cancel(e.exportMetrics(loopCtx, lastReset, rotations))
+		}()
+	}
+
+	// Stop the export goroutine, then export whatever the final,
+	// partial window holds.
+	stop := func() error {
+		cancel(nil)
+		<-exporterDone
+
+		e.exportFinalWindow(context.WithoutCancel(ctx))
+		return context.Cause(ctx)
+	}
+
 	for {
-		err := context.Cause(ctx)
-		if err != nil {
-			return err
+		select {
+		case <-loopCtx.Done():
+			cause := context.Cause(loopCtx)
+			log.Println("stopping:", cause)
+			if err := e.drainPackets(ctx, packets); err != nil {
+				return err
+			}
+			if err := stop(); err != nil || ctx.Err() != nil {
+				return err
+			}
+			// A source or the exporter failed.
+			return cause
+
+		case r := <-rotations:
+			r.reply <- e.resetAt(r.limit)
+
+		case packet, ok := <-packets:
+			if !ok {
+				readErrMu.Lock()
+				err := readErr
+				readErrMu.Unlock()
+				if err != nil {
+					return err
+				}
+				return stop()
+			}
+
+			err := e.handlePacket(loopCtx, packet)
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (e *Exporter) handlePacket(ctx Context, packet Packet) error {
+	if e.ReplayTimestamps {
+		e.replayUntil(ctx, packet.Metadata().Timestamp)
+	}
+
+	if e.dedup != nil && e.dedup.isDuplicate(packet) {
+		e.window.Health.PacketsDuplicate++
+		return nil
+	}
+	return e.Handle(ctx, packet)
+}
+
+// drainPackets handles any packets already read when the packet
+// handling loop is asked to stop.
+func (e *Exporter) drainPackets(ctx Context, packets <-chan Packet) error {
+	ctx = context.WithoutCancel(ctx)
+	for {
+		select {
+		case packet, ok := <-packets:
+			if !ok {
+				return nil
+			}
+			if err := e.handlePacket(ctx, packet); err != nil {
+				return err
+			}
+
+		default:
+			return nil
 		}
+	}
+}
 
+// readPackets feeds packets from ps to the packet handling loop,
+// tagging each with the index of the source it came from.
+func readPackets(ctx Context, index int, ps PacketSource,
+	packets chan<- Packet) error {
+
+	for {
 		packet, err := ps.NextPacket()
-		if err != nil {
+		if err == pcap.NextErrorTimeoutExpired {
+			if err := context.Cause(ctx); err != nil {
+				return err
+			}
+			continue
+		} else if err != nil {
 			return err
 		}
+		packet.Metadata().InterfaceIndex = index
 
-		err = e.Handle(ctx, packet)
-		if err != nil {
-			return err
+		select {
+		case packets <- packet:
+		case <-ctx.Done():
+			return context.Cause(ctx)
 		}
 	}
 }
 
-func (e *Exporter) Reset() map[HostPair]int {
-	e.mu.Lock()
-	defer e.mu.Unlock()
+// rotateRequest asks the packet handling loop to start a new window
+// at limit and hand over the old one.
+type rotateRequest struct {
+	limit time.Time
+	reply chan<- *Window
+}
 
-	counts := e.byteCounts
-	e.byteCounts = make(map[HostPair]int)
-	e.lastReset = time.Now()
+// Reset starts a new window, returning the old one.  Like Handle,
+// it must only be called from the packet handling goroutine.
+func (e *Exporter) Reset() *Window {
+	return e.resetAt(time.Now())
+}
+
+func (e *Exporter) resetAt(t time.Time) *Window {
+	window := e.window
+	e.window = newWindow()
+	e.lastReset = t
+
+	stats := e.captureStats()
+	if window != nil {
+		window.Health.countCaptureStats(stats, e.lastStats)
+		window.Health.DockerRescans = e.dockerRescans.Swap(0)
+	}
+	e.lastStats = stats
 
-	return counts
+	return window
 }
 
 func (e *Exporter) Handle(ctx Context, packet Packet) error {
-	layer := packet.Layer(layers.LayerTypeIPv4)
-	if layer == nil {
-		return nil
-	}
-	ip, ok := layer.(*layers.IPv4)
-	if !ok {
-		log.Printf("gopacket error")
-		return UnhandledPacketError(packet)
+	anomalous := packet.ErrorLayer() != nil
+
+	srcIP, dstIP, err := networkAddrs(packet)
+	if err != nil {
+		e.captureAnomaly(packet)
+		return err
 	}
 
-	layer = packet.Layer(layers.LayerTypeTCP)
-	if layer == nil {
+	layer := packet.Layer(layers.LayerTypeTCP)
+	if srcIP == nil || layer == nil {
+		e.window.Health.PacketsSkipped++
+		if anomalous {
+			e.captureAnomaly(packet)
+		}
 		return nil
 	}
 	tcp, ok := layer.(*layers.TCP)
 	if !ok {
 		log.Printf("gopacket error")
+		e.captureAnomaly(packet)
 		return UnhandledPacketError(packet)
 	}
 
-	src, err := e.Categorize(ctx, ip.SrcIP, tcp.SrcPort, tcp.DstPort)
-	if err != nil {
-		return err
+	size := len(packet.Data())
+	src, srcOK := e.endpointHost(ctx, srcIP, tcp.SrcPort,
+		dstIP, tcp.DstPort, size)
+	dst, dstOK := e.endpointHost(ctx, dstIP, tcp.DstPort,
+		srcIP, tcp.SrcPort, size)
+	if !srcOK || !dstOK {
+		anomalous = true
 	}
-	dst, err := e.Categorize(ctx, ip.DstIP, tcp.DstPort, tcp.SrcPort)
-	if err != nil {
-		return err
+	if anomalous || src == UnknownHost || dst == UnknownHost {
+		e.captureAnomaly(packet)
 	}
 
-	e.byteCounts[PairHosts(src, dst)] += len(packet.Data())
+	flow := Flow{
+		HostPair:  PairHosts(src, dst),
+		Port:      servicePort(tcp.SrcPort, tcp.DstPort, e.stratumPort()),
+		Interface: e.interfaceName(packet.Metadata().InterfaceIndex),
+	}
+	retransmit := e.seqs.isRetransmit(packet, tcp)
+	e.window.flowCounts(flow).countPacket(tcp, size, retransmit)
+	e.countTalker(src, dst, srcIP, dstIP, flow.Port, size)
+	e.checkPolicy(packet, flow, srcIP, dstIP, tcp)
+
+	if e.isStratum(tcp) {
+		e.stratumDecoder().Handle(packet, tcp)
+	}
+	if isLevin(src, dst, tcp) {
+		e.levinDecoder().Handle(packet, tcp, flow.HostPair)
+	}
 	return nil
 }
 
+// endpointHost returns the host at the ip:port end of a connection
+// to peerIP:peerPort, and false if it had to be tolerated.  Processes
+// are only consulted for this host's own addresses, and for those
+// Categorize can't place, so that traffic between containers never
+// waits on procfs.
+func (e *Exporter) endpointHost(ctx Context, ip net.IP, port layers.TCPPort,
+	peerIP net.IP, peerPort layers.TCPPort, size int) (Host, bool) {
+
+	if e.Processes != nil && e.Processes.IsLocal(ip) {
+		if host := e.Processes.Owner(ip, port, peerIP, peerPort); host != NilHost {
+			return host, true
+		}
+	}
+
+	host, err := e.Categorize(ctx, ip, port, peerPort)
+	if err == nil {
+		return host, true
+	}
+	if e.Processes != nil {
+		if owner := e.Processes.Owner(ip, port, peerIP, peerPort); owner != NilHost {
+			return owner, true
+		}
+	}
+	return e.tolerate(ip, host, err, size), false
+}
+
+func (e *Exporter) stratumPort() layers.TCPPort {
+	if e.StratumPort == 0 {
+		return DefaultStratumPort
+	}
+	return e.StratumPort
+}
+
+func (e *Exporter) isStratum(tcp *layers.TCP) bool {
+	port := e.stratumPort()
+	return tcp.SrcPort == port || tcp.DstPort == port
+}
+
+func (e *Exporter) stratumDecoder() *stratumDecoder {
+	if e.stratum == nil {
+		e.stratum = newStratumDecoder(e.stratumPort(),
+			func(worker string) *StratumCounts {
+				return e.window.stratumCounts(worker)
+			})
+	}
+	return e.stratum
+}
+
+func (e *Exporter) levinDecoder() *levinDecoder {
+	if e.levin == nil {
+		e.levin = newLevinDecoder(func(key LevinKey) *LevinCounts {
+			return e.window.levinCounts(key)
+		})
+	}
+	return e.levin
+}
+
+// networkAddrs returns the source and destination addresses of an
+// IPv4 or IPv6 packet, or nil addresses for anything else.
+func networkAddrs(packet Packet) (net.IP, net.IP, error) {
+	if layer := packet.Layer(layers.LayerTypeIPv4); layer != nil {
+		ip, ok := layer.(*layers.IPv4)
+		if !ok {
+			log.Printf("gopacket error")
+			return nil, nil, UnhandledPacketError(packet)
+		}
+		return ip.SrcIP, ip.DstIP, nil
+	}
+
+	if layer := packet.Layer(layers.LayerTypeIPv6); layer != nil {
+		ip, ok := layer.(*layers.IPv6)
+		if !ok {
+			log.Printf("gopacket error")
+			return nil, nil, UnhandledPacketError(packet)
+		}
+		return ip.SrcIP, ip.DstIP, nil
+	}
+
+	return nil, nil, nil
+}
+
+// Categorize returns the host ip belongs to.  If ip can't be
+// handled it returns an error along with its best guess, which may
+// be NilHost.
 func (e *Exporter) Categorize(ctx Context, ip net.IP,
 	port, peerPort layers.TCPPort) (Host, error) {
 
+	e.hostsMu.Lock()
 	result, err := e.categorize(ctx, ip, port, peerPort)
+	e.hostsMu.Unlock()
+
 	if err == nil && result == LocalMiner &&
-		port != StratumPort && peerPort != StratumPort {
+		port != e.stratumPort() && peerPort != e.stratumPort() {
 		err = UnhandledAddressError(ip)
 	}
-	if err != nil && result != NilHost {
-		log.Println("warning:", err)
-		err = nil
-	}
 	return result, err
 }
 
 func (e *Exporter) categorize(ctx Context, ip net.IP,
 	port, peerPort layers.TCPPort) (Host, error) {
 
-	if !ip.IsPrivate() {
-		if ip.IsGlobalUnicast() {
-			return ExternalHost, nil
-		}
-		return NilHost, UnhandledAddressError(ip)
-	}
-
 	wantCacheKey, err := knownHostsKey(ip)
 	if err != nil {
 		return NilHost, err
@@ -236,50 +640,43 @@ func (e *Exporter) categorize(ctx Context, ip net.IP,
 		return result, nil
 	}
 
-	log.Printf("%s: unknown host", ip)
-	log.Println("scanning Docker network")
-
-	network, err := e.dockerNetwork(ctx)
-	if err != nil {
-		return NilHost, err
+	// IsPrivate covers IPv6 unique local addresses (fc00::/7)
+	// too; link-local addresses are likewise only reachable from
+	// the local network, so they get looked up the same way.  So
+	// do global addresses in Docker networks' own subnets, which
+	// IPv6 networks often are; any others are the internet's.
+	local := ip.IsPrivate() || ip.IsLinkLocalUnicast()
+	if !local && !ip.IsGlobalUnicast() {
+		return NilHost, UnhandledAddressError(ip)
 	}
 
-	for _, endpoint := range network.Containers {
-		if endpoint.IPv4Address == "" {
-			continue
-		}
-
-		gotIP, _, err := net.ParseCIDR(endpoint.IPv4Address)
+	// watchDocker keeps knownHosts up to date, so the networks
+	// are only read here the first time they're needed.
+	if e.dockerHosts == nil && (local || e.DockerClient != nil) {
+		networks, err := e.dockerNetworks(ctx)
 		if err != nil {
-			log.Println("warning:", err)
-			continue
-		}
-
-		gotCacheKey, err := knownHostsKey(gotIP)
-		if err != nil {
-			log.Println("warning:", err)
-			continue
-		}
-
-		host, err := HostFromName(endpoint.Name)
-		if err != nil {
-			log.Println("warning:", err)
-			host = UnknownHost
+			if !local {
+				return ExternalHost, err
+			}
+			return NilHost, err
 		}
-
-		e.knowHost(gotCacheKey, host)
-
-		if gotCacheKey == wantCacheKey {
-			result = host
+		e.applyNetworks(networks)
+		if result, found := e.knownHosts[wantCacheKey]; found {
+			return result, nil
 		}
 	}
+	if !local && !e.inDockerSubnet(ip) {
+		return ExternalHost, nil
+	}
 
-	if result != NilHost {
-		return result, nil
+	// Don't log every packet of an address that wasn't found
+	// last time.
+	if e.offenders.seenRecently(ip, time.Now()) {
+		return UnknownHost, UnhandledAddressError(ip)
 	}
-	log.Printf("%s: not found in %s", ip, network.Name)
+	log.Printf("%s: not found in %s", ip, networkNames(e.DockerNetworks))
 
-	if peerPort == StratumPort {
+	if peerPort == e.stratumPort() {
 		log.Printf("%s: assuming to be local miner", ip)
 		result = LocalMiner
 		e.knowHost(wantCacheKey, result)
@@ -291,11 +688,50 @@ func (e *Exporter) categorize(ctx Context, ip net.IP,
 }
 
 func knownHostsKey(ip net.IP) (string, error) {
-	bytes := ip.To4()
-	if bytes == nil {
-		return "", UnhandledAddressError(ip)
+	if bytes := ip.To4(); bytes != nil {
+		return string(bytes), nil
+	}
+	if bytes := ip.To16(); bytes != nil {
+		return string(bytes), nil
+	}
+	return "", UnhandledAddressError(ip)
+}
+
+// inDockerSubnet reports whether ip is in the subnet of one of the
+// Docker networks.  The caller must hold e.hostsMu.
+func (e *Exporter) inDockerSubnet(ip net.IP) bool {
+	for _, network := range e.DockerNetworks {
+		for _, config := range network.IPAM.Config {
+			_, subnet, err := net.ParseCIDR(config.Subnet)
+			if err == nil && subnet.Contains(ip) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// endpointAddrs returns the IPv4 and IPv6 addresses of a Docker
+// network endpoint.
+func endpointAddrs(endpoint DockerEndpoint) []net.IP {
+	var addrs []net.IP
+	for _, cidr := range []string{
+		endpoint.IPv4Address,
+		endpoint.IPv6Address,
+	} {
+		if cidr == "" {
+			continue
+		}
+
+		ip, _, err := net.ParseCIDR(cidr)
+		if err != nil {
+			log.Println("warning:", err)
+			continue
+		}
+
+		addrs = append(addrs, ip)
 	}
-	return string(bytes), nil
+	return addrs
 }
 
 func (e *Exporter) knowHost(key string, host Host) {
@@ -312,30 +748,168 @@ func (e *Exporter) knowHost(key string, host Host) {
 	log.Println(net.IP(key), "=>", host)
 }
 
-func (e *Exporter) exportMetrics(ctx Context) error {
-	timer := time.NewTimer(e.nextUploadWait())
+func (e *Exporter) forgetHost(key string) {
+	if _, found := e.knownHosts[key]; !found {
+		return
+	}
+
+	delete(e.knownHosts, key)
+	log.Printf("%s: forgotten", net.IP(key))
+}
+
+// applyNetworks brings knownHosts into line with networks,
+// forgetting any addresses previously learned from Docker that are
+// no longer in use.  The caller must hold hostsMu.
+func (e *Exporter) applyNetworks(networks []*DockerNetwork) {
+	if e.dockerHosts == nil {
+		e.dockerHosts = make(map[string]string)
+	}
+
+	ids := make(map[string]bool, len(networks))
+	for _, network := range networks {
+		ids[network.ID] = true
+	}
+	for key, id := range e.dockerHosts {
+		if !ids[id] {
+			e.forgetHost(key)
+			delete(e.dockerHosts, key)
+		}
+	}
+
+	e.DockerNetworks = networks
+	for _, network := range networks {
+		e.applyNetwork(network)
+	}
+}
+
+// applyNetwork brings knownHosts into line with a new inspection of
+// one of DockerNetworks, leaving addresses learned from the others
+// alone.  Other networks are ignored.  The caller must hold hostsMu.
+func (e *Exporter) applyNetwork(network *DockerNetwork) {
+	i := slices.IndexFunc(e.DockerNetworks, func(cached *DockerNetwork) bool {
+		return cached.ID == network.ID
+	})
+	if i < 0 {
+		return
+	}
+	e.DockerNetworks[i] = network
+
+	hosts := networkHosts(network, e.containerRoles)
+	for key, id := range e.dockerHosts {
+		if _, found := hosts[key]; !found && id == network.ID {
+			e.forgetHost(key)
+			delete(e.dockerHosts, key)
+		}
+	}
+
+	for key, host := range hosts {
+		e.knowHost(key, host)
+		e.dockerHosts[key] = network.ID
+	}
+}
+
+// networkNames returns the names of networks, for logging.
+func networkNames(networks []*DockerNetwork) string {
+	names := make([]string, len(networks))
+	for i, network := range networks {
+		names[i] = network.Name
+	}
+	return strings.Join(names, ", ")
+}
+
+// networkHosts maps the addresses of every endpoint in network to
+// the host it belongs to.  Containers listed in roles are assigned
+// by role, and all others by name.
+func networkHosts(network *DockerNetwork,
+	roles map[string]string) map[string]Host {
+
+	hosts := make(map[string]Host)
+
+	for containerID, endpoint := range network.Containers {
+		addrs := endpointAddrs(endpoint)
+		if len(addrs) == 0 {
+			continue
+		}
+
+		host, err := endpointHost(containerID, endpoint, roles)
+		if err != nil {
+			log.Println("warning:", err)
+			host = UnknownHost
+		}
+
+		for _, ip := range addrs {
+			key, err := knownHostsKey(ip)
+			if err != nil {
+				log.Println("warning:", err)
+				continue
+			}
+
+			hosts[key] = host
+		}
+	}
+
+	return hosts
+}
+
+func endpointHost(containerID string, endpoint DockerEndpoint,
+	roles map[string]string) (Host, error) {
+
+	if role, found := roles[containerID]; found {
+		return HostFromRole(role)
+	}
+	return HostFromName(endpoint.Name)
+}
+
+func (e *Exporter) exportMetrics(ctx Context, lastReset time.Time,
+	rotations chan<- rotateRequest) error {
+
+	reply := make(chan *Window, 1)
+	timer := time.NewTimer(e.nextUploadWait(lastReset))
 	for {
 		select {
 		case <-ctx.Done():
 			return context.Cause(ctx)
 
 		case <-timer.C:
-			start := e.lastReset
-			counts := e.Reset()
-			limit := e.lastReset
-
-			err := e.uploadMetrics(ctx, limit, counts, limit.Sub(start))
-			if err != nil {
-				log.Println("warning:", err)
+			limit := time.Now()
+			select {
+			case rotations <- rotateRequest{limit, reply}:
+			case <-ctx.Done():
+				return context.Cause(ctx)
 			}
 
-			timer.Reset(e.nextUploadWait())
+			e.upload(ctx, lastReset, limit, <-reply)
+			lastReset = limit
+			timer.Reset(e.nextUploadWait(lastReset))
 		}
 	}
 }
 
-func (e *Exporter) nextUploadWait() time.Duration {
-	wait := time.Until(e.nextUploadTime())
+// exportWindow exports the current window from within the packet
+// handling goroutine.
+func (e *Exporter) exportWindow(ctx Context, limit time.Time) {
+	start := e.lastReset
+	window := e.resetAt(limit)
+
+	e.upload(ctx, start, limit, window)
+}
+
+// upload exports window.  Only one upload may run at a time.
+func (e *Exporter) upload(ctx Context, start, limit time.Time, window *Window) {
+	window.Health.LastExportDuration = e.lastExportDuration
+	log.Println("health:", &window.Health)
+	e.logTopTalkers()
+
+	began := time.Now()
+	err := e.uploadMetrics(ctx, limit, window, limit.Sub(start))
+	if err != nil {
+		log.Println("warning:", err)
+	}
+	e.lastExportDuration = time.Since(began)
+}
+
+func (e *Exporter) nextUploadWait(lastReset time.Time) time.Duration {
+	wait := time.Until(e.nextUploadTime(lastReset))
 	const minWait = time.Nanosecond
 	if wait < minWait {
 		return minWait
@@ -343,21 +917,26 @@ func (e *Exporter) nextUploadWait() time.Duration {
 	return wait
 }
 
-func (e *Exporter) nextUploadTime() time.Time {
+func (e *Exporter) nextUploadTime(lastReset time.Time) time.Time {
 	maxWait := e.ExportFrequency
 	if maxWait <= time.Duration(0) {
 		maxWait = DefaultExportFreq
 	}
 
-	return e.lastReset.Add(maxWait)
+	return lastReset.Add(maxWait)
 }
 
 func (e *Exporter) uploadMetrics(ctx Context,
 	t time.Time,
-	byteCounts map[HostPair]int,
+	window *Window,
 	d time.Duration) error {
 
 	log.Println(t, d)
 
-	return nil
+	e.addTotals(window)
+	e.checkpoint()
+	if e.Sink == nil {
+		return nil
+	}
+	return e.Sink.Export(ctx, t, d, window)
 }
@@ -0,0 +1,122 @@
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+)
+
+// fakeDocker is an in-memory DockerClient serving a single network.
+type fakeDocker struct {
+	mu          sync.Mutex
+	network     DockerNetwork
+	roles       map[string]string // by container ID
+	inspections int
+
+	events chan events.Message
+}
+
+func newFakeDocker(name string) *fakeDocker {
+	return &fakeDocker{
+		network: DockerNetwork{
+			Name:       name,
+			ID:         "0123456789abcdef0123456789abcdef",
+			Containers: make(map[string]DockerEndpoint),
+		},
+		roles:  make(map[string]string),
+		events: make(chan events.Message),
+	}
+}
+
+// connect attaches a container with the given address to the network.
+func (f *fakeDocker) connect(id, name, addr string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.network.Containers[id] = DockerEndpoint{
+		Name:        name,
+		IPv4Address: addr + "/16",
+	}
+}
+
+func (f *fakeDocker) disconnect(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	delete(f.network.Containers, id)
+}
+
+func (f *fakeDocker) inspectionCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.inspections
+}
+
+func (f *fakeDocker) NetworkInspect(ctx Context, networkID string,
+	options types.NetworkInspectOptions) (DockerNetwork, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.inspections++
+	if networkID != f.network.Name && networkID != f.network.ID {
+		return DockerNetwork{}, fmt.Errorf("network %s not found", networkID)
+	}
+
+	network := f.network
+	network.Containers = make(map[string]DockerEndpoint)
+	for id, endpoint := range f.network.Containers {
+		network.Containers[id] = endpoint
+	}
+	return network, nil
+}
+
+func (f *fakeDocker) ContainerList(ctx Context,
+	options types.ContainerListOptions) ([]types.Container, error) {
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var containers []types.Container
+	for id, role := range f.roles {
+		containers = append(containers, types.Container{
+			ID:     id,
+			Labels: map[string]string{RoleLabel: role},
+		})
+	}
+	return containers, nil
+}
+
+func (f *fakeDocker) Events(ctx Context,
+	options types.EventsOptions) (<-chan events.Message, <-chan error) {
+
+	// Like the real client, report ctx's error when it's done.
+	errs := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		errs <- ctx.Err()
+	}()
+	return f.events, errs
+}
+
+func (f *fakeDocker) Close() error {
+	return nil
+}
+
+// fakePacketSource supplies a fixed list of packets.
+type fakePacketSource struct {
+	packets []Packet
+}
+
+func (s *fakePacketSource) NextPacket() (Packet, error) {
+	if len(s.packets) == 0 {
+		return nil, io.EOF
+	}
+	packet := s.packets[0]
+	s.packets = s.packets[1:]
+	return packet, nil
+}
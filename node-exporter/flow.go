@@ -0,0 +1,60 @@
+package exporter
+
+import (
+	"fmt"
+
+	"github.com/google/gopacket/layers"
+)
+
+// A Flow is the unit of accounting: traffic from one host category
+// to another, involving one service port, seen on one interface.
+// Interface is empty for packets that didn't come from a named
+// device, such as those replayed from a file.  It's exported as the
+// interface label, which older exporters didn't have; totals they
+// saved are restored onto the capture device when there's only one.
+type Flow struct {
+	HostPair
+	Port      layers.TCPPort
+	Interface string
+}
+
+// Ports below this are assumed to be service ports when neither
+// side of a connection is listed in ServicePorts.
+const ephemeralPortStart = 32768
+
+// ServicePorts are the ports our services listen on.
+var ServicePorts = map[layers.TCPPort]bool{
+	18080:              true, // monerod P2P
+	18081:              true, // monerod RPC
+	18083:              true, // monerod ZMQ
+	18089:              true, // monerod restricted RPC
+	DefaultStratumPort: true, // p2pool stratum
+	37888:              true, // p2pool-mini P2P
+	37889:              true, // p2pool P2P
+	9050:               true, // Tor SOCKS proxy
+}
+
+// servicePort returns the port of whichever side of a connection
+// is the service, or zero if that can't be determined.
+func servicePort(srcPort, dstPort, stratumPort layers.TCPPort) layers.TCPPort {
+	if ServicePorts[srcPort] || srcPort == stratumPort {
+		return srcPort
+	}
+	if ServicePorts[dstPort] || dstPort == stratumPort {
+		return dstPort
+	}
+
+	port := min(srcPort, dstPort)
+	if port >= ephemeralPortStart {
+		return 0
+	}
+	return port
+}
+
+func (f Flow) String() string {
+	if f.Interface != "" {
+		return fmt.Sprintf("%s:%d@%s", f.HostPair, uint16(f.Port),
+			f.Interface)
+	}
+	return fmt.Sprintf("%s:%d", f.HostPair, uint16(f.Port))
+}
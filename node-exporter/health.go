@@ -0,0 +1,165 @@
+package exporter
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/gopacket/pcap"
+)
+
+// Health describes how trustworthy a window's counts are.
+type Health struct {
+	// Capture statistics reported by libpcap.  These are
+	// always zero when replaying a capture file.
+	PacketsReceived  uint64 `json:"packets_received"`
+	PacketsDropped   uint64 `json:"packets_dropped"`    // by the kernel
+	PacketsIfDropped uint64 `json:"packets_if_dropped"` // by the interface
+
+	// Packets that weren't counted because they weren't IPv4
+	// or IPv6 TCP.
+	PacketsSkipped uint64 `json:"packets_skipped"`
+
+	// Packets that weren't counted because a copy was already
+	// captured on another interface.
+	PacketsDuplicate uint64 `json:"packets_duplicate"`
+
+	// UnhandledAddressError warnings, by the category each
+	// address was assigned.
+	UnhandledAddresses map[string]uint64 `json:"unhandled_addresses,omitempty"`
+
+	DockerRescans uint64 `json:"docker_rescans"`
+
+	// Connections alerted on for carrying traffic the policy
+	// doesn't allow.
+	PolicyAlerts uint64 `json:"policy_alerts"`
+
+	// Time spent exporting the previous window.
+	LastExportDuration time.Duration `json:"last_export_ns"`
+}
+
+// Add adds everything counted in other to h.  LastExportDuration
+// isn't a count, so other's simply replaces h's.
+func (h *Health) Add(other *Health) {
+	h.PacketsReceived += other.PacketsReceived
+	h.PacketsDropped += other.PacketsDropped
+	h.PacketsIfDropped += other.PacketsIfDropped
+	h.PacketsSkipped += other.PacketsSkipped
+	h.PacketsDuplicate += other.PacketsDuplicate
+	for category, n := range other.UnhandledAddresses {
+		h.countUnhandledAddresses(category, n)
+	}
+	h.DockerRescans += other.DockerRescans
+	h.PolicyAlerts += other.PolicyAlerts
+	h.LastExportDuration = other.LastExportDuration
+}
+
+func (h *Health) countUnhandledAddresses(category string, n uint64) {
+	if h.UnhandledAddresses == nil {
+		h.UnhandledAddresses = make(map[string]uint64)
+	}
+	h.UnhandledAddresses[category] += n
+}
+
+// unhandledCategory returns the category UnhandledAddressError
+// warnings are counted under for addresses categorized as host.
+func unhandledCategory(host Host) string {
+	if host == NilHost {
+		return "unroutable"
+	}
+	return host.String()
+}
+
+// countCaptureStats sets h's capture statistics to the difference
+// between stats and prev.  libpcap's counters are 32 bits wide on
+// most platforms, and wrap.
+func (h *Health) countCaptureStats(stats, prev *pcap.Stats) {
+	if stats == nil || prev == nil {
+		return
+	}
+	delta := func(a, b int) uint64 {
+		return uint64(uint32(a) - uint32(b))
+	}
+	h.PacketsReceived = delta(stats.PacketsReceived, prev.PacketsReceived)
+	h.PacketsDropped = delta(stats.PacketsDropped, prev.PacketsDropped)
+	h.PacketsIfDropped = delta(stats.PacketsIfDropped, prev.PacketsIfDropped)
+}
+
+// String returns a one-line summary of h suitable for logging.
+func (h *Health) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "received %d, dropped %d (kernel) %d (interface),"+
+		" skipped %d, duplicate %d", h.PacketsReceived, h.PacketsDropped,
+		h.PacketsIfDropped, h.PacketsSkipped, h.PacketsDuplicate)
+
+	categories := make([]string, 0, len(h.UnhandledAddresses))
+	for category := range h.UnhandledAddresses {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	for _, category := range categories {
+		fmt.Fprintf(&b, ", %s addresses %d", category,
+			h.UnhandledAddresses[category])
+	}
+
+	fmt.Fprintf(&b, ", Docker rescans %d, policy alerts %d, last export %v",
+		h.DockerRescans, h.PolicyAlerts, h.LastExportDuration)
+	return b.String()
+}
+
+// captureStats returns the libpcap statistics for the live captures
+// in progress, summed, or nil if there aren't any.  Once the capture
+// handles are closed it returns the statistics read just before
+// closing.
+func (e *Exporter) captureStats() *pcap.Stats {
+	e.handleMu.Lock()
+	defer e.handleMu.Unlock()
+
+	if e.handlesClosed {
+		return e.finalStats
+	}
+	return e.liveStats()
+}
+
+// liveStats sums the statistics of every live capture.  The caller
+// must hold handleMu.
+func (e *Exporter) liveStats() *pcap.Stats {
+	if e.PacketFile != "" {
+		return nil
+	}
+
+	var total *pcap.Stats
+	for _, handle := range e.handles() {
+		stats, err := handle.Stats()
+		if err != nil {
+			log.Println("warning:", err)
+			return nil
+		}
+
+		if total == nil {
+			total = &pcap.Stats{}
+		}
+		total.PacketsReceived += stats.PacketsReceived
+		total.PacketsDropped += stats.PacketsDropped
+		total.PacketsIfDropped += stats.PacketsIfDropped
+	}
+	return total
+}
+
+// closeHandles closes every capture handle.  It's safe to call from
+// any goroutine, and more than once.
+func (e *Exporter) closeHandles() {
+	e.handleMu.Lock()
+	defer e.handleMu.Unlock()
+
+	if e.handlesClosed {
+		return
+	}
+	e.finalStats = e.liveStats()
+	for _, handle := range e.handles() {
+		handle.Close()
+	}
+	e.handlesClosed = true
+}
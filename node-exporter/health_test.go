@@ -0,0 +1,69 @@
+package exporter
+
+import (
+	"context"
+	"math"
+	"net"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+)
+
+func TestCaptureStatsWrap(t *testing.T) {
+	var h Health
+	h.countCaptureStats(
+		&pcap.Stats{PacketsReceived: 5, PacketsDropped: 2},
+		&pcap.Stats{PacketsReceived: math.MaxUint32 - 4, PacketsDropped: 2})
+
+	if h.PacketsReceived != 10 {
+		t.Errorf("got %d packets received, want 10", h.PacketsReceived)
+	}
+	if h.PacketsDropped != 0 {
+		t.Errorf("got %d packets dropped, want 0", h.PacketsDropped)
+	}
+}
+
+func TestHandleCountsSkippedPackets(t *testing.T) {
+	quietLogs(t)
+
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{2, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{2, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.IPv4(172, 18, 0, 9).To4(),
+		DstIP:    net.IPv4(172, 18, 0, 3).To4(),
+	}
+	udp := &layers.UDP{SrcPort: 53, DstPort: 53}
+	if err := udp.SetNetworkLayerForChecksum(ip); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true}
+	if err := gopacket.SerializeLayers(buf, opts, eth, ip, udp); err != nil {
+		t.Fatal(err)
+	}
+	packet := gopacket.NewPacket(buf.Bytes(), layers.LinkTypeEthernet,
+		gopacket.Default)
+
+	e := newTestExporter(nil)
+	e.Reset()
+	if err := e.Handle(context.Background(), packet); err != nil {
+		t.Fatal(err)
+	}
+
+	window := e.Reset()
+	if got := window.Health.PacketsSkipped; got != 1 {
+		t.Errorf("got %d packets skipped, want 1", got)
+	}
+	if len(window.Flows) != 0 {
+		t.Errorf("got flows %v, want none", window.Flows)
+	}
+}
@@ -1,6 +1,12 @@
 package exporter
 
-import "fmt"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+)
 
 type Host int
 
@@ -14,6 +20,23 @@ const (
 	P2PoolTorNode      = iota + 't'
 )
 
+// RoleLabel is the container label that assigns a container to a
+// host category, overriding any mapping by container name.
+const RoleLabel = "net.gbenson.monero-node.role"
+
+// Hosts defined at runtime are numbered from firstDefinedHost, up
+// to the largest value that HostPair can hold.
+const (
+	firstDefinedHost Host = 0x100
+	maxHost          Host = dstMask >> 1
+)
+
+var (
+	hostsMu   sync.RWMutex
+	nextHost  = firstDefinedHost
+	roleHosts = map[string]Host{}
+)
+
 var hostStrings = map[Host]string{
 	UnknownHost:   "unknown",
 	ExternalHost:  "internet",
@@ -23,13 +46,34 @@ var hostStrings = map[Host]string{
 	P2PoolTorNode: "p2pool-tor",
 }
 
+// NamedHosts maps container names to hosts.  It holds the defaults
+// for our docker-compose.yml, and is extended by LoadHostConfig.
+// Use NameHost rather than modifying it directly.
 var NamedHosts = map[string]Host{
 	"monerod":    MoneroNode,
 	"p2pool":     P2PoolNode,
 	"p2pool-tor": P2PoolTorNode,
 }
 
+// NamedProcesses maps the executable names of processes outside
+// Docker to hosts, for ProcessAttributor.  It's extended by
+// LoadHostConfig.  Use NameProcess rather than modifying it directly.
+var NamedProcesses = map[string]Host{
+	"monerod": MoneroNode,
+	"p2pool":  P2PoolNode,
+	"xmrig":   LocalMiner,
+}
+
+func init() {
+	for host, role := range hostStrings {
+		roleHosts[role] = host
+	}
+}
+
 func HostFromName(hostname string) (Host, error) {
+	hostsMu.RLock()
+	defer hostsMu.RUnlock()
+
 	result, ok := NamedHosts[hostname]
 	if ok {
 		return result, nil
@@ -37,8 +81,131 @@ func HostFromName(hostname string) (Host, error) {
 	return NilHost, UnknownHostError(hostname)
 }
 
+// HostFromProcess returns the host that processes running the
+// executable called name belong to.
+func HostFromProcess(name string) (Host, bool) {
+	hostsMu.RLock()
+	defer hostsMu.RUnlock()
+
+	result, ok := NamedProcesses[name]
+	return result, ok
+}
+
+// HostFromRole returns the host category called role, defining it
+// if it doesn't already exist.
+func HostFromRole(role string) (Host, error) {
+	if role == "" {
+		return NilHost, errors.New("empty role")
+	}
+
+	if result, ok := lookupRole(role); ok {
+		return result, nil
+	}
+
+	hostsMu.Lock()
+	defer hostsMu.Unlock()
+
+	return defineHost(role)
+}
+
+// lookupRole returns the host category called role, if it exists.
+func lookupRole(role string) (Host, bool) {
+	hostsMu.RLock()
+	defer hostsMu.RUnlock()
+
+	result, ok := roleHosts[role]
+	return result, ok
+}
+
+func defineHost(role string) (Host, error) {
+	if result, ok := roleHosts[role]; ok {
+		return result, nil
+	}
+	if nextHost > maxHost {
+		return NilHost, fmt.Errorf("%s: too many hosts", role)
+	}
+
+	result := nextHost
+	nextHost++
+
+	hostStrings[result] = role
+	roleHosts[role] = result
+	return result, nil
+}
+
+// NameHost maps containers called hostname to the host category
+// called role, defining it if necessary.
+func NameHost(hostname, role string) error {
+	host, err := HostFromRole(role)
+	if err != nil {
+		return err
+	}
+
+	hostsMu.Lock()
+	defer hostsMu.Unlock()
+
+	NamedHosts[hostname] = host
+	return nil
+}
+
+// NameProcess maps processes running the executable called name to
+// the host category called role, defining it if necessary.
+func NameProcess(name, role string) error {
+	host, err := HostFromRole(role)
+	if err != nil {
+		return err
+	}
+
+	hostsMu.Lock()
+	defer hostsMu.Unlock()
+
+	NamedProcesses[name] = host
+	return nil
+}
+
+// HostConfig is the format of the file read by LoadHostConfig.
+type HostConfig struct {
+	// Containers maps container names to host categories.
+	Containers map[string]string `json:"containers"`
+
+	// Processes maps executable names to host categories.
+	Processes map[string]string `json:"processes"`
+}
+
+// LoadHostConfig reads container and executable name to host
+// category mappings from a JSON file, for example:
+//
+//	{"containers": {"p2pool-mini": "p2pool", "wallet-rpc": "wallet"},
+//	 "processes": {"tor": "tor"}}
+func LoadHostConfig(filename string) error {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+
+	var config HostConfig
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	for hostname, role := range config.Containers {
+		if err := NameHost(hostname, role); err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+	}
+	for name, role := range config.Processes {
+		if err := NameProcess(name, role); err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+	}
+	return nil
+}
+
 func (host Host) String() string {
+	hostsMu.RLock()
 	result, ok := hostStrings[host]
+	hostsMu.RUnlock()
+
 	if !ok {
 		result = fmt.Sprintf("Host(%d)", host)
 	}
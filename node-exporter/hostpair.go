@@ -10,17 +10,30 @@ const (
 )
 
 func PairHosts(src, dst Host) HostPair {
-	return HostPair((src << dstBits) | (dst & dstMask))
+	return HostPair(packHosts(int64(src), int64(dst), dstBits))
 }
 
 func (p HostPair) Src() Host {
-	return Host(p >> dstBits)
+	src, _ := unpackHosts(int64(p), dstBits)
+	return Host(src)
 }
 
 func (p HostPair) Dst() Host {
-	return Host(p & dstMask)
+	_, dst := unpackHosts(int64(p), dstBits)
+	return Host(dst)
 }
 
 func (p HostPair) String() string {
 	return fmt.Sprintf("%s-%s", p.Src(), p.Dst())
 }
+
+// packHosts and unpackHosts implement HostPair for pairs of any
+// width, so the 32-bit layout can be tested on 64-bit platforms
+// and vice versa.
+func packHosts(src, dst int64, bits uint) int64 {
+	return (src << bits) | (dst & (1<<bits - 1))
+}
+
+func unpackHosts(p int64, bits uint) (src, dst int64) {
+	return p >> bits, p & (1<<bits - 1)
+}
@@ -0,0 +1,56 @@
+package exporter
+
+import "testing"
+
+func TestHostPairLayouts(t *testing.T) {
+	tests := []struct {
+		name  string
+		bits  uint // destination bits
+		width uint // total bits
+	}{
+		{"32-bit", 16, 32},
+		{"64-bit", 32, 64},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			maxHost := int64(1)<<(tt.bits-1) - 1
+			hosts := []int64{
+				int64(NilHost),
+				int64(UnknownHost),
+				int64(ExternalHost),
+				int64(P2PoolTorNode),
+				int64(firstDefinedHost),
+				maxHost,
+			}
+
+			for _, src := range hosts {
+				for _, dst := range hosts {
+					p := packHosts(src, dst, tt.bits)
+
+					// Truncate to the platform's int.
+					shift := 64 - tt.width
+					p = p << shift >> shift
+
+					gotSrc, gotDst := unpackHosts(p, tt.bits)
+					if gotSrc != src || gotDst != dst {
+						t.Errorf("%d,%d: got %d,%d", src, dst,
+							gotSrc, gotDst)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestPairHosts(t *testing.T) {
+	for _, src := range []Host{NilHost, LocalMiner, maxHost} {
+		for _, dst := range []Host{NilHost, P2PoolNode, maxHost} {
+			p := PairHosts(src, dst)
+			if p.Src() != src || p.Dst() != dst {
+				t.Errorf("PairHosts(%d, %d) unpacked to %d, %d",
+					src, dst, p.Src(), p.Dst())
+			}
+		}
+	}
+}
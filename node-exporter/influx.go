@@ -0,0 +1,196 @@
+package exporter
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Line protocol over UDP is split into datagrams of at most this
+// many bytes, to avoid fragmentation.
+const maxUDPPayload = 1400
+
+// InfluxSink writes each window as InfluxDB line protocol, either
+// to the HTTP write API or to a UDP listener.
+type InfluxSink struct {
+	// Receiver is the HTTP write endpoint, for example
+	// "http://localhost:8086/api/v2/write?org=o&bucket=b" or
+	// "http://localhost:8086/write?db=monero".  It's unused when
+	// writing over UDP.  InfluxDB 2.x takes an API token, and 1.x's
+	// /write takes "USER:PASSWORD" as its access token.
+	Receiver APIEndpoint
+
+	conn net.Conn
+}
+
+// NewInfluxSink returns an InfluxSink writing to rawURL, which is
+// either an HTTP write endpoint or "udp://HOST:PORT".
+func NewInfluxSink(rawURL string) (*InfluxSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "http", "https":
+		// InfluxDB 1.x's /write doesn't understand 2.x's tokens.
+		scheme := "Token"
+		if !strings.HasSuffix(u.Path, "/api/v2/write") {
+			scheme = "Basic"
+		}
+		return &InfluxSink{
+			Receiver: APIEndpoint{URL: rawURL, AuthScheme: scheme},
+		}, nil
+
+	case "udp":
+		conn, err := net.Dial("udp", u.Host)
+		if err != nil {
+			return nil, err
+		}
+		return &InfluxSink{conn: conn}, nil
+	}
+
+	return nil, fmt.Errorf("%s: not an HTTP or UDP URL", rawURL)
+}
+
+func (s *InfluxSink) Export(ctx Context,
+	t time.Time, d time.Duration, w *Window) error {
+
+	lines := influxLines(t, windowSeries(w))
+	if len(lines) == 0 {
+		return nil
+	}
+
+	if s.conn != nil {
+		return writeDatagrams(s.conn, lines, maxUDPPayload)
+	}
+
+	body := []byte(strings.Join(lines, "\n") + "\n")
+	_, err := s.Receiver.postBody(ctx, "text/plain; charset=utf-8", body)
+	return err
+}
+
+func (s *InfluxSink) Close() error {
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+// A series is one set of counts from a window, in a form that maps
+// onto both InfluxDB points and tagged StatsD counters.
+type series struct {
+	measurement string
+	tags        []seriesTag
+	fields      []seriesField
+}
+
+type seriesTag struct {
+	key, value string
+}
+
+type seriesField struct {
+	name  string
+	value uint64
+}
+
+// windowSeries returns everything counted in w as series, in the
+// same order as in its Report.
+func windowSeries(w *Window) []series {
+	r := NewReport(time.Time{}, 0, w)
+
+	var result []series
+	for i := range r.Flows {
+		f := &r.Flows[i]
+		tags := []seriesTag{
+			{"src", f.Src},
+			{"dst", f.Dst},
+			{"port", strconv.Itoa(int(f.Port))},
+		}
+		if f.Interface != "" {
+			tags = append(tags, seriesTag{"interface", f.Interface})
+		}
+		result = append(result, series{"monero_node_flow", tags,
+			metricFields(flowMetrics, "monero_node_", &f.Counts)})
+	}
+
+	for i := range r.Stratum {
+		s := &r.Stratum[i]
+		result = append(result, series{"monero_node_stratum",
+			[]seriesTag{{"worker", s.Worker}},
+			metricFields(stratumMetrics, "monero_node_stratum_",
+				&s.StratumCounts)})
+	}
+
+	for i := range r.Levin {
+		l := &r.Levin[i]
+		tags := []seriesTag{
+			{"src", l.Src},
+			{"dst", l.Dst},
+			{"command", l.Command},
+		}
+		result = append(result, series{"monero_node_levin", tags,
+			metricFields(levinMetrics, "monero_node_levin_",
+				&l.LevinCounts)})
+	}
+
+	result = append(result, series{"monero_node_exporter", nil,
+		metricFields(healthMetrics, "monero_node_exporter_", &r.Health)})
+	return result
+}
+
+// metricFields returns the value of each of metrics in v, named by
+// stripping prefix and "_total" from the metric's name.
+func metricFields[T any](metrics []metric[T], prefix string,
+	v *T) []seriesField {
+
+	fields := make([]seriesField, len(metrics))
+	for i, m := range metrics {
+		name := strings.TrimPrefix(m.name, prefix)
+		fields[i] = seriesField{strings.TrimSuffix(name, "_total"),
+			m.value(v)}
+	}
+	return fields
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(
+		`,`, `\,`, ` `, `\ `, "\n", `\n`)
+	influxKeyEscaper = strings.NewReplacer(
+		`,`, `\,`, `=`, `\=`, ` `, `\ `, "\n", `\n`)
+)
+
+// influxLines formats each of ss as a line of InfluxDB line
+// protocol, timestamped t.  Tags with empty values are omitted, as
+// InfluxDB rejects them.
+func influxLines(t time.Time, ss []series) []string {
+	timestamp := strconv.FormatInt(t.UnixNano(), 10)
+
+	lines := make([]string, 0, len(ss))
+	for _, s := range ss {
+		var b strings.Builder
+		b.WriteString(influxMeasurementEscaper.Replace(s.measurement))
+		for _, tag := range s.tags {
+			if tag.value == "" {
+				continue
+			}
+			fmt.Fprintf(&b, ",%s=%s", influxKeyEscaper.Replace(tag.key),
+				influxKeyEscaper.Replace(tag.value))
+		}
+		for i, field := range s.fields {
+			sep := ","
+			if i == 0 {
+				sep = " "
+			}
+			fmt.Fprintf(&b, "%s%s=%di", sep,
+				influxKeyEscaper.Replace(field.name), field.value)
+		}
+		b.WriteString(" ")
+		b.WriteString(timestamp)
+		lines = append(lines, b.String())
+	}
+	return lines
+}
@@ -0,0 +1,168 @@
+package exporter
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newSeriesTestWindow() *Window {
+	w := newWindow()
+	flow := Flow{
+		HostPair:  PairHosts(P2PoolNode, ExternalHost),
+		Port:      37889,
+		Interface: "br 0",
+	}
+	counts := w.flowCounts(flow)
+	counts.Bytes = 1500
+	counts.Packets = 3
+	w.Health.PacketsReceived = 4
+	return w
+}
+
+func TestInfluxLines(t *testing.T) {
+	ts := time.Unix(1700000000, 0)
+	lines := influxLines(ts, windowSeries(newSeriesTestWindow()))
+
+	want := []string{
+		`monero_node_flow,src=p2pool,dst=internet,port=37889,interface=br\ 0` +
+			` bytes=1500i,packets=3i,tcp_opens=0i,tcp_closes=0i,tcp_resets=0i,` +
+			`tcp_retransmits=0i 1700000000000000000`,
+		`monero_node_exporter packets_received=4i,packets_dropped=0i,` +
+			`packets_if_dropped=0i,packets_skipped=0i,packets_duplicate=0i,` +
+			`docker_rescans=0i,policy_alerts=0i 1700000000000000000`,
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got:\n%s\nwant:\n%s", strings.Join(lines, "\n"),
+			strings.Join(want, "\n"))
+	}
+}
+
+func TestInfluxSinkHTTP(t *testing.T) {
+	var auth, body string
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			auth = r.Header.Get("Authorization")
+			b, _ := io.ReadAll(r.Body)
+			body = string(b)
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	defer srv.Close()
+
+	sink, err := NewInfluxSink(srv.URL + "/api/v2/write?org=o&bucket=b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Receiver.AccessToken = "secret"
+
+	err = sink.Export(context.Background(), time.Now(), time.Minute,
+		newSeriesTestWindow())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if auth != "Token secret" {
+		t.Errorf("got Authorization %q", auth)
+	}
+	if !strings.HasPrefix(body, "monero_node_flow,src=p2pool,") ||
+		strings.Count(body, "\n") != 2 {
+		t.Errorf("unexpected body %q", body)
+	}
+}
+
+func TestInfluxSinkV1Auth(t *testing.T) {
+	var user, password string
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			user, password, _ = r.BasicAuth()
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	defer srv.Close()
+
+	sink, err := NewInfluxSink(srv.URL + "/write?db=monero")
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.Receiver.AccessToken = "exporter:secret"
+
+	err = sink.Export(context.Background(), time.Now(), time.Minute,
+		newSeriesTestWindow())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user != "exporter" || password != "secret" {
+		t.Errorf("got user %q password %q, want exporter secret",
+			user, password)
+	}
+}
+
+func TestWindowSeriesBoundsWorkers(t *testing.T) {
+	w := newWindow()
+	for i := 0; i < 2*maxWorkers; i++ {
+		w.stratumCounts(fmt.Sprintf("wallet%d", i)).Logins++
+	}
+	w.stratumCounts(strings.Repeat("4", 2*maxWorkerLen)).Logins++
+
+	var workers int
+	var other uint64
+	for _, s := range windowSeries(w) {
+		if s.measurement != "monero_node_stratum" {
+			continue
+		}
+		workers++
+		if worker := s.tags[0].value; len(worker) > maxWorkerLen {
+			t.Errorf("worker %q is longer than %d bytes", worker, maxWorkerLen)
+		} else if worker == OtherWorkers {
+			other = s.fields[0].value
+		}
+	}
+	if workers != maxWorkers+1 {
+		t.Errorf("got %d workers, want %d", workers, maxWorkers+1)
+	}
+	if want := uint64(maxWorkers + 1); other != want {
+		t.Errorf("got %d logins from other workers, want %d", other, want)
+	}
+}
+
+func TestInfluxSinkUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	sink, err := NewInfluxSink("udp://" + conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	err = sink.Export(context.Background(), time.Now(), time.Minute,
+		newSeriesTestWindow())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := readDatagram(t, conn)
+	if lines := strings.Split(got, "\n"); len(lines) != 2 ||
+		!strings.HasPrefix(lines[1], "monero_node_exporter ") {
+		t.Errorf("unexpected datagram %q", got)
+	}
+}
+
+func readDatagram(t *testing.T, conn net.PacketConn) string {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 65536)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf[:n])
+}
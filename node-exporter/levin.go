@@ -0,0 +1,203 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+)
+
+const MoneroP2PPort = 18080
+
+// LevinKey identifies levin messages of one command in one direction.
+type LevinKey struct {
+	HostPair
+	Command LevinCommand
+}
+
+// LevinCounts are the statistics accumulated for each LevinKey.
+type LevinCounts struct {
+	Messages uint64 `json:"messages"`
+	Bytes    uint64 `json:"bytes"`
+}
+
+func (c *LevinCounts) Add(other *LevinCounts) {
+	c.Messages += other.Messages
+	c.Bytes += other.Bytes
+}
+
+type LevinCommand uint32
+
+var levinCommandStrings = map[LevinCommand]string{
+	1001: "handshake",
+	1002: "timed_sync",
+	1003: "ping",
+	1007: "support_flags",
+	2001: "new_block",
+	2002: "new_transactions",
+	2003: "request_get_objects",
+	2004: "response_get_objects",
+	2006: "request_chain",
+	2007: "response_chain_entry",
+	2008: "new_fluffy_block",
+	2009: "request_fluffy_missing_tx",
+	2010: "get_txpool_complement",
+}
+
+func (c LevinCommand) String() string {
+	result, ok := levinCommandStrings[c]
+	if !ok {
+		result = fmt.Sprint(uint32(c))
+	}
+	return result
+}
+
+// ParseLevinCommand is the inverse of LevinCommand.String.
+func ParseLevinCommand(s string) (LevinCommand, error) {
+	for c, name := range levinCommandStrings {
+		if name == s {
+			return c, nil
+		}
+	}
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil {
+		return 0, fmt.Errorf("%s: unknown levin command", s)
+	}
+	return LevinCommand(n), nil
+}
+
+// The levin header is a little-endian signature, payload length,
+// have-to-return flag, command, return code, flags and protocol
+// version, in that order.
+const (
+	levinSignature       = 0x0101010101012101
+	levinHeaderSize      = 33
+	levinCommandOffset   = 17
+	maxLevinPayload      = 100 << 20
+	maxLevinPagesPerConn = 64
+)
+
+var levinSignatureBytes = binary.LittleEndian.AppendUint64(nil, levinSignature)
+
+// levinDecoder reassembles monerod P2P streams and counts the levin
+// messages inside them.
+type levinDecoder struct {
+	assembler *tcpassembly.Assembler
+	counts    func(LevinKey) *LevinCounts
+	pair      HostPair // of the packet being assembled
+	lastFlush time.Time
+}
+
+type levinStream struct {
+	decoder *levinDecoder
+	pair    HostPair
+	buf     []byte
+	skip    uint64 // payload bytes still to pass over
+}
+
+func newLevinDecoder(counts func(LevinKey) *LevinCounts) *levinDecoder {
+	d := &levinDecoder{counts: counts}
+	d.assembler = tcpassembly.NewAssembler(tcpassembly.NewStreamPool(d))
+	d.assembler.MaxBufferedPagesPerConnection = maxLevinPagesPerConn
+	return d
+}
+
+// isLevin reports whether a packet between src and dst should be
+// decoded as monerod P2P traffic.
+func isLevin(src, dst Host, tcp *layers.TCP) bool {
+	if tcp.SrcPort != MoneroP2PPort && tcp.DstPort != MoneroP2PPort {
+		return false
+	}
+	return (src == MoneroNode && dst == ExternalHost) ||
+		(src == ExternalHost && dst == MoneroNode)
+}
+
+func (d *levinDecoder) Handle(packet Packet, tcp *layers.TCP, pair HostPair) {
+	netLayer := packet.NetworkLayer()
+	if netLayer == nil {
+		return
+	}
+
+	t := packet.Metadata().Timestamp
+	if t.IsZero() {
+		t = time.Now()
+	}
+	d.pair = pair
+	d.assembler.AssembleWithTimestamp(netLayer.NetworkFlow(), tcp, t)
+
+	if t.Sub(d.lastFlush) > streamFlushInterval {
+		d.assembler.FlushOlderThan(t.Add(-streamFlushAge))
+		d.lastFlush = t
+	}
+}
+
+// New implements tcpassembly.StreamFactory.
+func (d *levinDecoder) New(netFlow, tcpFlow gopacket.Flow) tcpassembly.Stream {
+	return &levinStream{decoder: d, pair: d.pair}
+}
+
+// Reassembled implements tcpassembly.Stream.
+func (s *levinStream) Reassembled(rs []tcpassembly.Reassembly) {
+	for _, r := range rs {
+		data := r.Bytes
+		if r.Skip != 0 {
+			// Data was lost; resynchronize at the next header.
+			s.buf = s.buf[:0]
+			s.skip = 0
+		}
+
+		if s.skip > 0 {
+			n := min(s.skip, uint64(len(data)))
+			data = data[n:]
+			s.skip -= n
+		}
+
+		s.buf = append(s.buf, data...)
+		s.parse()
+	}
+}
+
+func (s *levinStream) parse() {
+	buf := s.buf
+	for len(buf) >= levinHeaderSize {
+		if !bytes.HasPrefix(buf, levinSignatureBytes) {
+			i := bytes.Index(buf[1:], levinSignatureBytes)
+			if i < 0 {
+				buf = buf[len(buf)-len(levinSignatureBytes)+1:]
+				break
+			}
+			buf = buf[i+1:]
+			continue
+		}
+
+		length := binary.LittleEndian.Uint64(buf[8:])
+		if length > maxLevinPayload {
+			buf = buf[1:]
+			continue
+		}
+		command := binary.LittleEndian.Uint32(buf[levinCommandOffset:])
+
+		counts := s.decoder.counts(LevinKey{s.pair, LevinCommand(command)})
+		counts.Messages++
+		counts.Bytes += levinHeaderSize + length
+
+		buf = buf[levinHeaderSize:]
+		if uint64(len(buf)) >= length {
+			buf = buf[length:]
+		} else {
+			s.skip = length - uint64(len(buf))
+			buf = buf[:0]
+		}
+	}
+	s.buf = append(s.buf[:0], buf...)
+}
+
+// ReassemblyComplete implements tcpassembly.Stream.
+func (s *levinStream) ReassemblyComplete() {
+	s.buf = nil
+}
@@ -0,0 +1,285 @@
+package exporter
+
+import (
+	"bufio"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const metricsContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// MetricsHandler returns an http.Handler that serves the cumulative
+// counts in the Prometheus text exposition format, and the offending
+// addresses and top talkers as JSON.
+func (e *Exporter) MetricsHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", e.serveMetrics)
+	mux.HandleFunc("/offenders", e.serveOffenders)
+	mux.HandleFunc("/top-talkers", e.serveTopTalkers)
+	mux.HandleFunc("/alerts", e.serveAlerts)
+	return mux
+}
+
+func (e *Exporter) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", metricsContentType)
+
+	b := bufio.NewWriter(w)
+	defer b.Flush()
+
+	writeMetrics(b, e.Totals())
+}
+
+func writeMetrics(w *bufio.Writer, totals *Window) {
+	flows := make([]Flow, 0, len(totals.Flows))
+	for flow := range totals.Flows {
+		flows = append(flows, flow)
+	}
+	sortFlows(flows)
+
+	labels := make([]string, len(flows))
+	for i, flow := range flows {
+		labels[i] = fmt.Sprintf("src=%s,dst=%s,port=%s,interface=%s",
+			quoteLabel(flow.Src().String()),
+			quoteLabel(flow.Dst().String()),
+			quoteLabel(strconv.Itoa(int(flow.Port))),
+			quoteLabel(flow.Interface))
+	}
+
+	for _, m := range flowMetrics {
+		writeCounter(w, m.name, m.help, labels, func(i int) uint64 {
+			return m.value(totals.Flows[flows[i]])
+		})
+	}
+
+	workers := make([]string, 0, len(totals.Stratum))
+	for worker := range totals.Stratum {
+		workers = append(workers, worker)
+	}
+	sort.Strings(workers)
+
+	labels = make([]string, len(workers))
+	for i, worker := range workers {
+		labels[i] = "worker=" + quoteLabel(worker)
+	}
+
+	for _, m := range stratumMetrics {
+		writeCounter(w, m.name, m.help, labels, func(i int) uint64 {
+			return m.value(totals.Stratum[workers[i]])
+		})
+	}
+
+	keys := make([]LevinKey, 0, len(totals.Levin))
+	for key := range totals.Levin {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		if a.HostPair != b.HostPair {
+			return a.HostPair.String() < b.HostPair.String()
+		}
+		return a.Command < b.Command
+	})
+
+	labels = make([]string, len(keys))
+	for i, key := range keys {
+		labels[i] = fmt.Sprintf("src=%s,dst=%s,command=%s",
+			quoteLabel(key.Src().String()),
+			quoteLabel(key.Dst().String()),
+			quoteLabel(key.Command.String()))
+	}
+
+	for _, m := range levinMetrics {
+		writeCounter(w, m.name, m.help, labels, func(i int) uint64 {
+			return m.value(totals.Levin[keys[i]])
+		})
+	}
+
+	writeHealthMetrics(w, &totals.Health)
+}
+
+var healthMetrics = []metric[Health]{
+	{
+		"monero_node_exporter_packets_received_total",
+		"Packets received by libpcap.",
+		func(h *Health) uint64 { return h.PacketsReceived },
+	}, {
+		"monero_node_exporter_packets_dropped_total",
+		"Packets dropped by the kernel before libpcap saw them.",
+		func(h *Health) uint64 { return h.PacketsDropped },
+	}, {
+		"monero_node_exporter_packets_if_dropped_total",
+		"Packets dropped by the network interface.",
+		func(h *Health) uint64 { return h.PacketsIfDropped },
+	}, {
+		"monero_node_exporter_packets_skipped_total",
+		"Packets skipped as neither IPv4 nor IPv6 TCP.",
+		func(h *Health) uint64 { return h.PacketsSkipped },
+	}, {
+		"monero_node_exporter_packets_duplicate_total",
+		"Packets skipped as copies of ones captured on another interface.",
+		func(h *Health) uint64 { return h.PacketsDuplicate },
+	}, {
+		"monero_node_exporter_docker_rescans_total",
+		"Docker network inspections performed.",
+		func(h *Health) uint64 { return h.DockerRescans },
+	}, {
+		"monero_node_exporter_policy_alerts_total",
+		"Connections seen carrying traffic the policy doesn't allow.",
+		func(h *Health) uint64 { return h.PolicyAlerts },
+	},
+}
+
+func writeHealthMetrics(w *bufio.Writer, h *Health) {
+	for _, m := range healthMetrics {
+		fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+		fmt.Fprintf(w, "# TYPE %s counter\n", m.name)
+		fmt.Fprintf(w, "%s %d\n", m.name, m.value(h))
+	}
+
+	categories := make([]string, 0, len(h.UnhandledAddresses))
+	for category := range h.UnhandledAddresses {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+
+	labels := make([]string, len(categories))
+	for i, category := range categories {
+		labels[i] = "category=" + quoteLabel(category)
+	}
+	writeCounter(w, "monero_node_exporter_unhandled_addresses_total",
+		"Unhandled address warnings, by host category.",
+		labels, func(i int) uint64 {
+			return h.UnhandledAddresses[categories[i]]
+		})
+
+	const name = "monero_node_exporter_last_export_seconds"
+	fmt.Fprintf(w, "# HELP %s Time spent in the last export.\n", name)
+	fmt.Fprintf(w, "# TYPE %s gauge\n", name)
+	fmt.Fprintf(w, "%s %g\n", name, h.LastExportDuration.Seconds())
+}
+
+type metric[T any] struct {
+	name  string
+	help  string
+	value func(*T) uint64
+}
+
+var flowMetrics = []metric[Counts]{
+	{
+		"monero_node_bytes_total",
+		"Bytes of TCP traffic observed.",
+		func(c *Counts) uint64 { return c.Bytes },
+	}, {
+		"monero_node_packets_total",
+		"TCP packets observed.",
+		func(c *Counts) uint64 { return c.Packets },
+	}, {
+		"monero_node_tcp_opens_total",
+		"TCP connection attempts (SYN without ACK) observed.",
+		func(c *Counts) uint64 { return c.Opens },
+	}, {
+		"monero_node_tcp_closes_total",
+		"TCP connection closes (FIN) observed.",
+		func(c *Counts) uint64 { return c.Closes },
+	}, {
+		"monero_node_tcp_resets_total",
+		"TCP connection resets (RST) observed.",
+		func(c *Counts) uint64 { return c.Resets },
+	}, {
+		"monero_node_tcp_retransmits_total",
+		"Estimated TCP retransmissions observed.",
+		func(c *Counts) uint64 { return c.Retransmits },
+	},
+}
+
+var stratumMetrics = []metric[StratumCounts]{
+	{
+		"monero_node_stratum_logins_total",
+		"Stratum logins observed.",
+		func(c *StratumCounts) uint64 { return c.Logins },
+	}, {
+		"monero_node_stratum_jobs_total",
+		"Stratum job notifications observed.",
+		func(c *StratumCounts) uint64 { return c.Jobs },
+	}, {
+		"monero_node_stratum_shares_submitted_total",
+		"Stratum shares submitted.",
+		func(c *StratumCounts) uint64 { return c.Submits },
+	}, {
+		"monero_node_stratum_shares_accepted_total",
+		"Stratum shares accepted.",
+		func(c *StratumCounts) uint64 { return c.Accepted },
+	}, {
+		"monero_node_stratum_shares_rejected_total",
+		"Stratum shares rejected.",
+		func(c *StratumCounts) uint64 { return c.Rejected },
+	},
+}
+
+var levinMetrics = []metric[LevinCounts]{
+	{
+		"monero_node_levin_messages_total",
+		"Monero P2P (levin) messages observed.",
+		func(c *LevinCounts) uint64 { return c.Messages },
+	}, {
+		"monero_node_levin_bytes_total",
+		"Bytes of Monero P2P (levin) messages observed.",
+		func(c *LevinCounts) uint64 { return c.Bytes },
+	},
+}
+
+// writeCounter writes one sample per element of labels, which are
+// preformatted label sets.
+func writeCounter(w *bufio.Writer, name, help string,
+	labels []string, value func(int) uint64) {
+
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+
+	for i, l := range labels {
+		fmt.Fprintf(w, "%s{%s} %d\n", name, l, value(i))
+	}
+}
+
+func sortFlows(flows []Flow) {
+	sort.Slice(flows, func(i, j int) bool {
+		a, b := flows[i], flows[j]
+		if a.HostPair != b.HostPair {
+			return a.HostPair.String() < b.HostPair.String()
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.Interface < b.Interface
+	})
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func quoteLabel(s string) string {
+	return `"` + labelEscaper.Replace(s) + `"`
+}
+
+// Totals returns a copy of the cumulative counts exported so far.
+func (e *Exporter) Totals() *Window {
+	e.totalsMu.Lock()
+	defer e.totalsMu.Unlock()
+
+	if e.totals == nil {
+		return newWindow()
+	}
+	return e.totals.Clone()
+}
+
+func (e *Exporter) addTotals(window *Window) {
+	e.totalsMu.Lock()
+	defer e.totalsMu.Unlock()
+
+	if e.totals == nil {
+		e.totals = newWindow()
+	}
+	e.totals.Add(window)
+}
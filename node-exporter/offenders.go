@@ -0,0 +1,151 @@
+package exporter
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// At most this many offending addresses are remembered;
+	// once the table is full the least recently seen is evicted.
+	maxOffenders = 256
+
+	// Warnings about any one address are logged at most this
+	// often.
+	offenderLogInterval = time.Minute
+)
+
+// An Offender is an address the exporter couldn't categorize, and
+// whose traffic was counted under UnknownHost or its best guess.
+type Offender struct {
+	Addr      string    `json:"addr"`
+	Host      string    `json:"host"`
+	Error     string    `json:"error"`
+	FirstSeen time.Time `json:"first_seen"`
+	LastSeen  time.Time `json:"last_seen"`
+	Packets   uint64    `json:"packets"`
+	Bytes     uint64    `json:"bytes"`
+
+	lastLogged time.Time
+	suppressed uint64
+}
+
+type offenderTable struct {
+	mu      sync.Mutex
+	entries map[string]*Offender
+}
+
+// record accounts for one packet of size bytes to or from ip,
+// logging err unless a warning about ip was logged recently.
+func (t *offenderTable) record(ip net.IP, host Host, err error,
+	size int, now time.Time) {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	addr := ip.String()
+	o := t.entries[addr]
+	if o == nil {
+		if t.entries == nil {
+			t.entries = make(map[string]*Offender)
+		}
+		if len(t.entries) >= maxOffenders {
+			t.evict()
+		}
+		o = &Offender{Addr: addr, FirstSeen: now}
+		t.entries[addr] = o
+	}
+
+	o.Host = host.String()
+	o.Error = err.Error()
+	o.LastSeen = now
+	o.Packets++
+	o.Bytes += uint64(size)
+
+	if now.Sub(o.lastLogged) < offenderLogInterval {
+		o.suppressed++
+		return
+	}
+	if o.suppressed != 0 {
+		log.Printf("warning: %v (counted as %s; %d more suppressed)",
+			err, host, o.suppressed)
+	} else {
+		log.Printf("warning: %v (counted as %s)", err, host)
+	}
+	o.lastLogged = now
+	o.suppressed = 0
+}
+
+// evict forgets the least recently seen offender.  The caller must
+// hold t.mu.
+func (t *offenderTable) evict() {
+	var oldest *Offender
+	for _, o := range t.entries {
+		if oldest == nil || o.LastSeen.Before(oldest.LastSeen) {
+			oldest = o
+		}
+	}
+	if oldest != nil {
+		delete(t.entries, oldest.Addr)
+	}
+}
+
+// seenRecently reports whether a warning about ip was logged within
+// the last offenderLogInterval.
+func (t *offenderTable) seenRecently(ip net.IP, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	o := t.entries[ip.String()]
+	return o != nil && now.Sub(o.lastLogged) < offenderLogInterval
+}
+
+// list returns a copy of every offender, most bytes first.
+func (t *offenderTable) list() []Offender {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := make([]Offender, 0, len(t.entries))
+	for _, o := range t.entries {
+		result = append(result, *o)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := &result[i], &result[j]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		return a.Addr < b.Addr
+	})
+	return result
+}
+
+// Offenders returns the addresses the exporter couldn't categorize,
+// most bytes first.
+func (e *Exporter) Offenders() []Offender {
+	return e.offenders.list()
+}
+
+func (e *Exporter) serveOffenders(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(e.Offenders()); err != nil {
+		log.Println("warning:", err)
+	}
+}
+
+// tolerate applies the exporter's policy for addresses Categorize
+// couldn't handle: the traffic is still counted, under host if
+// Categorize had a best guess or under UnknownHost otherwise, and
+// the address is recorded in the offenders table.
+func (e *Exporter) tolerate(ip net.IP, host Host, err error, size int) Host {
+	e.window.Health.countUnhandledAddresses(unhandledCategory(host), 1)
+	if host == NilHost {
+		host = UnknownHost
+	}
+	e.offenders.record(ip, host, err, size, time.Now())
+	return host
+}
@@ -0,0 +1,101 @@
+package exporter
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+func newTCPPacket(t testing.TB, src string, srcPort uint16,
+	dst string, dstPort uint16) Packet {
+
+	eth := &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{2, 0, 0, 0, 0, 1},
+		DstMAC:       net.HardwareAddr{2, 0, 0, 0, 0, 2},
+		EthernetType: layers.EthernetTypeIPv4,
+	}
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolTCP,
+		SrcIP:    net.ParseIP(src).To4(),
+		DstIP:    net.ParseIP(dst).To4(),
+	}
+	tcp := &layers.TCP{
+		SrcPort: layers.TCPPort(srcPort),
+		DstPort: layers.TCPPort(dstPort),
+		ACK:     true,
+	}
+	if err := tcp.SetNetworkLayerForChecksum(ip); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true}
+	err := gopacket.SerializeLayers(buf, opts, eth, ip, tcp,
+		gopacket.Payload(make([]byte, 10)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return gopacket.NewPacket(buf.Bytes(), layers.LinkTypeEthernet,
+		gopacket.Default)
+}
+
+func TestHandleToleratesUnknownAddresses(t *testing.T) {
+	quietLogs(t)
+
+	e := newTestExporter(nil)
+	e.Reset()
+
+	packet := newTCPPacket(t, "172.18.0.99", 40000, "172.18.0.3", 18080)
+	for i := 0; i < 3; i++ {
+		if err := e.Handle(context.Background(), packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	window := e.Reset()
+	flow := Flow{HostPair: PairHosts(UnknownHost, P2PoolNode), Port: 18080}
+	if counts := window.Flows[flow]; counts == nil || counts.Packets != 3 {
+		t.Errorf("got %v for %v, want 3 packets", counts, flow)
+	}
+	if got := window.Health.UnhandledAddresses["unknown"]; got != 3 {
+		t.Errorf("got %d unhandled addresses, want 3", got)
+	}
+
+	offenders := e.Offenders()
+	if len(offenders) != 1 {
+		t.Fatalf("got %d offenders, want 1", len(offenders))
+	}
+	o := offenders[0]
+	if o.Addr != "172.18.0.99" || o.Packets != 3 ||
+		o.Bytes != 3*uint64(len(packet.Data())) {
+		t.Errorf("unexpected offender %+v", o)
+	}
+}
+
+func TestOffenderTableIsBounded(t *testing.T) {
+	quietLogs(t)
+
+	var table offenderTable
+	start := time.Now()
+	for i := 0; i < maxOffenders+10; i++ {
+		ip := net.IPv4(10, 0, byte(i>>8), byte(i))
+		now := start.Add(time.Duration(i) * time.Second)
+		table.record(ip, UnknownHost, UnhandledAddressError(ip), 1, now)
+	}
+
+	offenders := table.list()
+	if len(offenders) != maxOffenders {
+		t.Fatalf("got %d offenders, want %d", len(offenders), maxOffenders)
+	}
+	for _, o := range offenders {
+		if o.Addr == "10.0.0.0" {
+			t.Errorf("oldest offender %s not evicted", o.Addr)
+		}
+	}
+}
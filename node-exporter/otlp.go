@@ -0,0 +1,220 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	otlpMetricsPath = "/v1/metrics"
+
+	// AGGREGATION_TEMPORALITY_DELTA: each window's counts are
+	// reported as they are, rather than accumulated.
+	otlpDeltaTemporality = 1
+)
+
+// OTLPSink pushes each window's flow counts to an OpenTelemetry
+// collector using OTLP/HTTP with JSON encoding, for hosts nothing
+// can scrape.
+type OTLPSink struct {
+	Receiver APIEndpoint
+
+	// Resource attributes identifying this host.
+	Hostname string // host.name
+	Provider string // cloud.provider, omitted if empty
+}
+
+// NewOTLPSink returns an OTLPSink for the collector at rawURL.  If
+// rawURL has no path, the standard OTLP/HTTP metrics path is used.
+func NewOTLPSink(rawURL, provider string) (*OTLPSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = otlpMetricsPath
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+
+	return &OTLPSink{
+		Receiver: APIEndpoint{URL: u.String()},
+		Hostname: hostname,
+		Provider: provider,
+	}, nil
+}
+
+func (s *OTLPSink) Export(ctx Context,
+	t time.Time, d time.Duration, w *Window) error {
+
+	body, err := s.Receiver.post(ctx, s.request(t, d, w))
+	if err != nil {
+		return err
+	}
+
+	var res otlpResponse
+	if len(body) == 0 || json.Unmarshal(body, &res) != nil {
+		return nil
+	}
+	if p := res.PartialSuccess; p != nil && p.RejectedDataPoints != "" &&
+		p.RejectedDataPoints != "0" {
+		return fmt.Errorf("%s: %s data points rejected: %s",
+			s.Receiver.URL, p.RejectedDataPoints, p.ErrorMessage)
+	}
+	return nil
+}
+
+// request builds an ExportMetricsServiceRequest holding w's flow
+// counts, one delta sum per metric in flowMetrics.
+func (s *OTLPSink) request(t time.Time, d time.Duration,
+	w *Window) *otlpRequest {
+
+	flows := make([]Flow, 0, len(w.Flows))
+	for flow := range w.Flows {
+		flows = append(flows, flow)
+	}
+	sortFlows(flows)
+
+	start := otlpTime(t.Add(-d))
+	end := otlpTime(t)
+
+	metrics := make([]otlpMetric, 0, len(flowMetrics))
+	for _, m := range flowMetrics {
+		points := make([]otlpDataPoint, len(flows))
+		for i, flow := range flows {
+			points[i] = otlpDataPoint{
+				Attributes:        flowAttributes(flow),
+				StartTimeUnixNano: start,
+				TimeUnixNano:      end,
+				AsInt:             strconv.FormatUint(m.value(w.Flows[flow]), 10),
+			}
+		}
+
+		metrics = append(metrics, otlpMetric{
+			Name:        strings.TrimSuffix(m.name, "_total"),
+			Description: m.help,
+			Unit:        otlpUnit(m.name),
+			Sum: otlpSum{
+				AggregationTemporality: otlpDeltaTemporality,
+				IsMonotonic:            true,
+				DataPoints:             points,
+			},
+		})
+	}
+
+	resource := []otlpKeyValue{
+		otlpString("service.name", "monero-node-exporter"),
+		otlpString("host.name", s.Hostname),
+	}
+	if s.Provider != "" {
+		resource = append(resource, otlpString("cloud.provider", s.Provider))
+	}
+
+	return &otlpRequest{
+		ResourceMetrics: []otlpResourceMetrics{{
+			Resource: otlpResource{Attributes: resource},
+			ScopeMetrics: []otlpScopeMetrics{{
+				Scope:   otlpScope{Name: "gbenson.net/monero-node/node-exporter"},
+				Metrics: metrics,
+			}},
+		}},
+	}
+}
+
+func flowAttributes(flow Flow) []otlpKeyValue {
+	attrs := []otlpKeyValue{
+		otlpString("src", flow.Src().String()),
+		otlpString("dst", flow.Dst().String()),
+		{Key: "port", Value: otlpAnyValue{
+			IntValue: strconv.Itoa(int(flow.Port)),
+		}},
+	}
+	if flow.Interface != "" {
+		attrs = append(attrs, otlpString("interface", flow.Interface))
+	}
+	return attrs
+}
+
+func otlpUnit(name string) string {
+	if strings.HasSuffix(name, "_bytes_total") {
+		return "By"
+	}
+	return "1"
+}
+
+// OTLP's JSON encoding follows the protobuf JSON mapping, in which
+// 64-bit integers are strings.
+func otlpTime(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+func otlpString(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: &value}}
+}
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpScope    `json:"scope"`
+	Metrics []otlpMetric `json:"metrics"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpMetric struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Unit        string  `json:"unit,omitempty"`
+	Sum         otlpSum `json:"sum"`
+}
+
+type otlpSum struct {
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+}
+
+type otlpDataPoint struct {
+	Attributes        []otlpKeyValue `json:"attributes"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	TimeUnixNano      string         `json:"timeUnixNano"`
+	AsInt             string         `json:"asInt"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    string  `json:"intValue,omitempty"`
+}
+
+type otlpResponse struct {
+	PartialSuccess *struct {
+		RejectedDataPoints json.Number `json:"rejectedDataPoints"`
+		ErrorMessage       string      `json:"errorMessage"`
+	} `json:"partialSuccess"`
+}
@@ -0,0 +1,126 @@
+package exporter
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// otlpCollector stands in for an OpenTelemetry collector, keeping
+// every request it receives.
+type otlpCollector struct {
+	mu       sync.Mutex
+	requests []otlpRequest
+	received chan struct{}
+}
+
+func (c *otlpCollector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != otlpMetricsPath ||
+		r.Header.Get("Content-Type") != "application/json" {
+		http.NotFound(w, r)
+		return
+	}
+
+	var req otlpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	c.requests = append(c.requests, req)
+	if len(c.requests) == 1 {
+		close(c.received)
+	}
+	c.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write([]byte(`{"partialSuccess":{}}`))
+}
+
+// waitingPacketSource supplies a fixed list of packets, then waits
+// for done before reporting EOF.
+type waitingPacketSource struct {
+	fakePacketSource
+	done <-chan struct{}
+}
+
+func (s *waitingPacketSource) NextPacket() (Packet, error) {
+	if len(s.packets) == 0 {
+		<-s.done
+	}
+	return s.fakePacketSource.NextPacket()
+}
+
+func TestOTLPSinkPushesOnTimer(t *testing.T) {
+	quietLogs(t)
+
+	collector := &otlpCollector{received: make(chan struct{})}
+	srv := httptest.NewServer(collector)
+	defer srv.Close()
+
+	sink, err := NewOTLPSink(srv.URL, "test-cloud")
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := newTestExporter(sink)
+
+	ps := &waitingPacketSource{done: collector.received}
+	var packet Packet
+	for i := 0; i < 3; i++ {
+		packet = newTCPPacket(t, "172.18.0.3", 37889, "203.0.113.7", 40000)
+		ps.packets = append(ps.packets, packet)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := e.handlePackets(ctx, ps); err != nil {
+		t.Fatal(err)
+	}
+
+	collector.mu.Lock()
+	defer collector.mu.Unlock()
+
+	var bytes uint64
+	for _, req := range collector.requests {
+		rm := req.ResourceMetrics[0]
+		attrs := make(map[string]string)
+		for _, kv := range rm.Resource.Attributes {
+			attrs[kv.Key] = *kv.Value.StringValue
+		}
+		if attrs["host.name"] != sink.Hostname ||
+			attrs["cloud.provider"] != "test-cloud" {
+			t.Errorf("unexpected resource attributes %v", attrs)
+		}
+
+		for _, m := range rm.ScopeMetrics[0].Metrics {
+			if m.Name != "monero_node_bytes" {
+				continue
+			}
+			if m.Unit != "By" ||
+				m.Sum.AggregationTemporality != otlpDeltaTemporality {
+				t.Errorf("unexpected metric %+v", m)
+			}
+			for _, p := range m.Sum.DataPoints {
+				if p.Attributes[0].Value.StringValue == nil ||
+					*p.Attributes[0].Value.StringValue != "p2pool" {
+					t.Errorf("unexpected attributes %+v", p.Attributes)
+				}
+				n, err := strconv.ParseUint(p.AsInt, 10, 64)
+				if err != nil {
+					t.Fatal(err)
+				}
+				bytes += n
+			}
+		}
+	}
+
+	if want := 3 * uint64(len(packet.Data())); bytes != want {
+		t.Errorf("collector got %d bytes, want %d", bytes, want)
+	}
+}
@@ -0,0 +1,315 @@
+package exporter
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// syntheticSource generates count packets, cycling through a fixed
+// set of flows between external hosts, p2pool and a local miner.
+type syntheticSource struct {
+	packets [][]byte
+	count   int
+	sent    int
+
+	// If idle is non-nil, close drained and block on idle once
+	// count packets have been sent, rather than returning io.EOF.
+	idle    chan struct{}
+	drained chan struct{}
+}
+
+func newSyntheticSource(t testing.TB, count int) *syntheticSource {
+	type endpoint struct {
+		ip   string
+		port uint16
+	}
+	pairs := [][2]endpoint{
+		{{"203.0.113.1", 18080}, {"198.51.100.7", 40001}},
+		{{"198.51.100.7", 40001}, {"203.0.113.1", 18080}},
+		{{"172.18.0.9", 40002}, {"172.18.0.3", DefaultStratumPort}},
+		{{"172.18.0.3", DefaultStratumPort}, {"172.18.0.9", 40002}},
+		{{"2001:db8::1", 37888}, {"2001:db8::2", 40003}},
+	}
+
+	s := &syntheticSource{count: count}
+	for i, pair := range pairs {
+		src, dst := pair[0], pair[1]
+		tcp := &layers.TCP{
+			SrcPort: layers.TCPPort(src.port),
+			DstPort: layers.TCPPort(dst.port),
+			Seq:     uint32(i * 1000),
+			ACK:     true,
+			Window:  1024,
+		}
+
+		eth := &layers.Ethernet{
+			SrcMAC: net.HardwareAddr{2, 0, 0, 0, 0, 1},
+			DstMAC: net.HardwareAddr{2, 0, 0, 0, 0, 2},
+		}
+		var ip gopacket.NetworkLayer
+		if srcIP := net.ParseIP(src.ip); srcIP.To4() != nil {
+			eth.EthernetType = layers.EthernetTypeIPv4
+			ip = &layers.IPv4{
+				Version:  4,
+				TTL:      64,
+				Protocol: layers.IPProtocolTCP,
+				SrcIP:    srcIP.To4(),
+				DstIP:    net.ParseIP(dst.ip).To4(),
+			}
+		} else {
+			eth.EthernetType = layers.EthernetTypeIPv6
+			ip = &layers.IPv6{
+				Version:    6,
+				HopLimit:   64,
+				NextHeader: layers.IPProtocolTCP,
+				SrcIP:      srcIP,
+				DstIP:      net.ParseIP(dst.ip),
+			}
+		}
+		if err := tcp.SetNetworkLayerForChecksum(ip); err != nil {
+			t.Fatal(err)
+		}
+
+		buf := gopacket.NewSerializeBuffer()
+		opts := gopacket.SerializeOptions{
+			FixLengths:       true,
+			ComputeChecksums: true,
+		}
+		err := gopacket.SerializeLayers(buf, opts,
+			eth, ip.(gopacket.SerializableLayer), tcp,
+			gopacket.Payload(make([]byte, 100)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.packets = append(s.packets, buf.Bytes())
+	}
+	return s
+}
+
+// ReadPacketData implements gopacket.PacketDataSource.
+func (s *syntheticSource) ReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if s.sent >= s.count {
+		if s.idle != nil {
+			close(s.drained)
+			<-s.idle
+		}
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+	data := s.packets[s.sent%len(s.packets)]
+	s.sent++
+
+	ci := gopacket.CaptureInfo{
+		Timestamp:     time.Now(),
+		CaptureLength: len(data),
+		Length:        len(data),
+	}
+	return data, ci, nil
+}
+
+func (s *syntheticSource) totalBytes() uint64 {
+	var total uint64
+	for i := 0; i < s.count; i++ {
+		total += uint64(len(s.packets[i%len(s.packets)]))
+	}
+	return total
+}
+
+// collectingSink records every window it receives.
+type collectingSink struct {
+	mu      sync.Mutex
+	windows []*Window
+}
+
+func (s *collectingSink) Export(ctx Context,
+	t time.Time, d time.Duration, w *Window) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.windows = append(s.windows, w)
+	return nil
+}
+
+func (s *collectingSink) totals() *Window {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	totals := newWindow()
+	for _, w := range s.windows {
+		totals.Add(w)
+	}
+	return totals
+}
+
+func newTestExporter(sink Sink) *Exporter {
+	e := &Exporter{
+		DockerNetworks:  []*DockerNetwork{{Name: "test"}},
+		ExportFrequency: 5 * time.Millisecond,
+		Sink:            sink,
+	}
+	key, _ := knownHostsKey(net.ParseIP("172.18.0.3"))
+	e.knowHost(key, P2PoolNode)
+	return e
+}
+
+func quietLogs(t testing.TB) {
+	saved := log.Writer()
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(saved) })
+}
+
+func TestHandlePacketsLosesNothing(t *testing.T) {
+	quietLogs(t)
+
+	count := 200000
+	if testing.Short() {
+		count = 20000
+	}
+	src := newSyntheticSource(t, count)
+	sink := &collectingSink{}
+	e := newTestExporter(sink)
+
+	ps := gopacket.NewPacketSource(src, layers.LinkTypeEthernet)
+	start := time.Now()
+	if err := e.handlePackets(context.Background(), ps); err != nil {
+		t.Fatal(err)
+	}
+	elapsed := time.Since(start)
+	t.Logf("%d packets in %v (%.0f packets/s), %d windows",
+		count, elapsed, float64(count)/elapsed.Seconds(),
+		len(sink.windows))
+
+	var packets, bytes uint64
+	for _, counts := range sink.totals().Flows {
+		packets += counts.Packets
+		bytes += counts.Bytes
+	}
+	if packets != uint64(count) {
+		t.Errorf("got %d packets, want %d", packets, count)
+	}
+	if want := src.totalBytes(); bytes != want {
+		t.Errorf("got %d bytes, want %d", bytes, want)
+	}
+
+	totals := e.Totals()
+	miner := Flow{HostPair: PairHosts(LocalMiner, P2PoolNode), Port: DefaultStratumPort}
+	if totals.Flows[miner] == nil {
+		t.Errorf("no %v flow in %v", miner, totals.Flows)
+	}
+}
+
+func TestHandlePacketsFlushesOnCancel(t *testing.T) {
+	quietLogs(t)
+
+	count := 1000
+	src := newSyntheticSource(t, count)
+	src.idle = make(chan struct{})
+	src.drained = make(chan struct{})
+	defer close(src.idle)
+
+	sink := &collectingSink{}
+	e := newTestExporter(sink)
+	e.ExportFrequency = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ps := gopacket.NewPacketSource(src, layers.LinkTypeEthernet)
+	done := make(chan error)
+	go func() {
+		done <- e.handlePackets(ctx, ps)
+	}()
+
+	select {
+	case <-src.drained:
+		cancel()
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for packets to be read")
+	}
+
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("got %v, want %v", err, context.Canceled)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for shutdown")
+	}
+
+	sink.mu.Lock()
+	windows := len(sink.windows)
+	sink.mu.Unlock()
+	if windows != 1 {
+		t.Fatalf("got %d windows, want 1", windows)
+	}
+
+	var packets uint64
+	for _, counts := range sink.totals().Flows {
+		packets += counts.Packets
+	}
+	if packets != uint64(count) {
+		t.Errorf("got %d packets, want %d", packets, count)
+	}
+}
+
+func BenchmarkHandlePackets(b *testing.B) {
+	quietLogs(b)
+
+	src := newSyntheticSource(b, b.N)
+	e := newTestExporter(nil)
+
+	ps := gopacket.NewPacketSource(src, layers.LinkTypeEthernet)
+	b.ResetTimer()
+	if err := e.handlePackets(context.Background(), ps); err != nil {
+		b.Fatal(err)
+	}
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "packets/s")
+}
+
+// failingPacketSource returns err once its packets run out.
+type failingPacketSource struct {
+	fakePacketSource
+	err error
+}
+
+func (s *failingPacketSource) NextPacket() (Packet, error) {
+	if len(s.packets) == 0 {
+		return nil, s.err
+	}
+	return s.fakePacketSource.NextPacket()
+}
+
+func TestRunReturnsCaptureError(t *testing.T) {
+	quietLogs(t)
+
+	e := newTestExporter(&collectingSink{})
+	e.DockerClient = newFakeDocker("test")
+	e.ExportFrequency = time.Hour
+
+	want := errors.New("device went away")
+	packet := newTCPPacket(t, "172.18.0.3", 40000, "203.0.113.7", 18080)
+	e.PacketSource = &failingPacketSource{
+		fakePacketSource: fakePacketSource{packets: []Packet{packet}},
+		err:              want,
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- e.Run(context.Background())
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, want) {
+			t.Errorf("got %v, want %v", err, want)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for Run to fail")
+	}
+}
@@ -0,0 +1,281 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	// At most this many alerts are kept for /alerts.
+	maxAlerts = 256
+
+	// Each connection is alerted on at most this often.
+	alertRepeatInterval = 10 * time.Minute
+)
+
+// A Policy restricts which hosts some host categories may exchange
+// traffic with.  Each key is a restricted host, and its value lists
+// the only hosts it's allowed to talk to, in either direction.
+// Hosts that aren't keys are unrestricted.
+type Policy map[Host]map[Host]bool
+
+// DefaultPolicy keeps local miners talking only to P2Pool, so that
+// mining traffic can't leave other than over P2Pool's Tor service.
+// Unlabelled stratum clients count as local miners.
+func DefaultPolicy() Policy {
+	return Policy{
+		LocalMiner: {P2PoolNode: true, P2PoolTorNode: true},
+	}
+}
+
+// Allows reports whether traffic between the hosts of pair is
+// allowed.
+func (p Policy) Allows(pair HostPair) bool {
+	src, dst := pair.Src(), pair.Dst()
+	if allowed, restricted := p[src]; restricted && !allowed[dst] {
+		return false
+	}
+	if allowed, restricted := p[dst]; restricted && !allowed[src] {
+		return false
+	}
+	return true
+}
+
+// LoadPolicy reads a Policy from a JSON file mapping host categories
+// to the categories they may talk to, for example:
+//
+//	{"local-miner": ["p2pool", "p2pool-tor"], "wallet": ["monero"]}
+//
+// Each category must be built in, named by the hosts file, or one
+// of labelled, the roles containers are labelled with, so that a
+// misspelt category is an error rather than a policy that never
+// matches.
+func LoadPolicy(filename string, labelled ...string) (Policy, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var roles map[string][]string
+	if err := json.Unmarshal(data, &roles); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	lookup := func(role string) (Host, error) {
+		if host, ok := lookupRole(role); ok {
+			return host, nil
+		}
+		if !slices.Contains(labelled, role) {
+			return NilHost, fmt.Errorf("%s: unknown host category %q",
+				filename, role)
+		}
+		host, err := HostFromRole(role)
+		if err != nil {
+			return NilHost, fmt.Errorf("%s: %w", filename, err)
+		}
+		return host, nil
+	}
+
+	policy := make(Policy, len(roles))
+	for role, peerRoles := range roles {
+		host, err := lookup(role)
+		if err != nil {
+			return nil, err
+		}
+
+		allowed := make(map[Host]bool, len(peerRoles))
+		for _, peerRole := range peerRoles {
+			peer, err := lookup(peerRole)
+			if err != nil {
+				return nil, err
+			}
+			allowed[peer] = true
+		}
+		policy[host] = allowed
+	}
+	return policy, nil
+}
+
+// loadPolicy replaces Policy with the one in PolicyFile, which may
+// name the roles of labelled containers as well as the categories
+// LoadPolicy always knows.
+func (e *Exporter) loadPolicy(ctx Context) error {
+	var labelled []string
+	if e.PacketFile == "" {
+		roles, err := e.listContainerRoles(ctx)
+		if err != nil {
+			return err
+		}
+		for _, role := range roles {
+			labelled = append(labelled, role)
+		}
+	}
+
+	policy, err := LoadPolicy(e.PolicyFile, labelled...)
+	if err != nil {
+		return err
+	}
+	e.Policy = policy
+	return nil
+}
+
+// An Alert reports traffic the exporter's Policy doesn't allow.
+type Alert struct {
+	Time      time.Time `json:"time"`
+	Flow      string    `json:"flow"`
+	Interface string    `json:"interface,omitempty"`
+	Protocol  string    `json:"protocol"`
+	SrcAddr   string    `json:"src_addr"`
+	SrcPort   uint16    `json:"src_port"`
+	DstAddr   string    `json:"dst_addr"`
+	DstPort   uint16    `json:"dst_port"`
+}
+
+func (a *Alert) String() string {
+	s := fmt.Sprintf("disallowed %s flow: %s %s -> %s", a.Flow, a.Protocol,
+		net.JoinHostPort(a.SrcAddr, strconv.Itoa(int(a.SrcPort))),
+		net.JoinHostPort(a.DstAddr, strconv.Itoa(int(a.DstPort))))
+	if a.Interface != "" {
+		s += " on " + a.Interface
+	}
+	return s
+}
+
+type alertTable struct {
+	mu     sync.Mutex
+	recent []Alert  // oldest first
+	keys   []string // the connection of each recent alert
+
+	// When each connection was last alerted on, keyed by its
+	// endpoints in either order.  Connections are forgotten along
+	// with their last alert, so this holds at most maxAlerts.
+	lastRaised map[string]time.Time
+}
+
+// raise records a, reporting false if its connection was already
+// alerted on within the last alertRepeatInterval.
+func (t *alertTable) raise(a Alert) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	src := net.JoinHostPort(a.SrcAddr, strconv.Itoa(int(a.SrcPort)))
+	dst := net.JoinHostPort(a.DstAddr, strconv.Itoa(int(a.DstPort)))
+	key := src + " " + dst
+	if dst < src {
+		key = dst + " " + src
+	}
+
+	if a.Time.Sub(t.lastRaised[key]) < alertRepeatInterval {
+		return false
+	}
+	if t.lastRaised == nil {
+		t.lastRaised = make(map[string]time.Time)
+	}
+	t.lastRaised[key] = a.Time
+
+	if len(t.recent) >= maxAlerts {
+		oldest, oldestKey := t.recent[0], t.keys[0]
+		if t.lastRaised[oldestKey].Equal(oldest.Time) {
+			delete(t.lastRaised, oldestKey)
+		}
+		t.recent = append(t.recent[:0], t.recent[1:]...)
+		t.keys = append(t.keys[:0], t.keys[1:]...)
+	}
+	t.recent = append(t.recent, a)
+	t.keys = append(t.keys, key)
+	return true
+}
+
+// list returns a copy of the recent alerts, newest first.
+func (t *alertTable) list() []Alert {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := append([]Alert(nil), t.recent...)
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Time.After(result[j].Time)
+	})
+	return result
+}
+
+// Alerts returns the most recent policy alerts, newest first.
+func (e *Exporter) Alerts() []Alert {
+	return e.alerts.list()
+}
+
+func (e *Exporter) serveAlerts(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(e.Alerts()); err != nil {
+		log.Println("warning:", err)
+	}
+}
+
+// policyPair returns the hosts the Policy judges flow by.  Stratum
+// is mining traffic whoever sends it, so a stratum client that isn't
+// placed as anything more specific than the internet is judged as a
+// LocalMiner.  Miners that are neither labelled nor inferred, and
+// that talk to pools on other ports, can't be told apart from any
+// other traffic, so aren't alerted on.
+func (e *Exporter) policyPair(flow Flow, tcp *layers.TCP) HostPair {
+	port := e.stratumPort()
+	if flow.Port != port {
+		return flow.HostPair
+	}
+
+	unplaced := func(host Host) bool {
+		return host == NilHost || host == UnknownHost || host == ExternalHost
+	}
+	src, dst := flow.Src(), flow.Dst()
+	switch {
+	case tcp.DstPort == port && unplaced(src):
+		src = LocalMiner
+	case tcp.SrcPort == port && unplaced(dst):
+		dst = LocalMiner
+	}
+	return PairHosts(src, dst)
+}
+
+// checkPolicy raises an alert if flow isn't allowed by the exporter's
+// Policy.
+func (e *Exporter) checkPolicy(packet Packet, flow Flow,
+	srcIP, dstIP net.IP, tcp *layers.TCP) {
+
+	if e.Policy == nil || e.Policy.Allows(e.policyPair(flow, tcp)) {
+		return
+	}
+
+	a := Alert{
+		Time:      packet.Metadata().Timestamp,
+		Flow:      flow.HostPair.String(),
+		Interface: flow.Interface,
+		Protocol:  "tcp",
+		SrcAddr:   srcIP.String(),
+		SrcPort:   uint16(tcp.SrcPort),
+		DstAddr:   dstIP.String(),
+		DstPort:   uint16(tcp.DstPort),
+	}
+	if a.Time.IsZero() {
+		a.Time = time.Now()
+	}
+	if !e.alerts.raise(a) {
+		return
+	}
+
+	e.window.Health.PolicyAlerts++
+	log.Println("alert:", &a)
+	e.captureAnomaly(packet)
+	if e.OnAlert != nil {
+		e.OnAlert(a)
+	}
+}
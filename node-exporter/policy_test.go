@@ -0,0 +1,199 @@
+package exporter
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPolicyAllows(t *testing.T) {
+	policy := DefaultPolicy()
+	for _, tc := range []struct {
+		src, dst Host
+		want     bool
+	}{
+		{LocalMiner, P2PoolNode, true},
+		{P2PoolTorNode, LocalMiner, true},
+		{LocalMiner, ExternalHost, false},
+		{ExternalHost, LocalMiner, false},
+		{LocalMiner, MoneroNode, false},
+		{P2PoolNode, ExternalHost, true},
+	} {
+		pair := PairHosts(tc.src, tc.dst)
+		if got := policy.Allows(pair); got != tc.want {
+			t.Errorf("Allows(%v) = %v, want %v", pair, got, tc.want)
+		}
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "policy.json")
+	err := os.WriteFile(filename,
+		[]byte(`{"local-miner": ["p2pool-tor"], "test-wallet": ["monero"]}`),
+		0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policy, err := LoadPolicy(filename, "test-wallet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	wallet, _ := HostFromRole("test-wallet")
+	if policy.Allows(PairHosts(LocalMiner, P2PoolNode)) {
+		t.Error("miner allowed to talk to p2pool")
+	}
+	if !policy.Allows(PairHosts(wallet, MoneroNode)) {
+		t.Error("wallet not allowed to talk to monero")
+	}
+	if policy.Allows(PairHosts(ExternalHost, wallet)) {
+		t.Error("internet allowed to talk to wallet")
+	}
+}
+
+func TestLoadPolicyRejectsUnknownRoles(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "policy.json")
+	err := os.WriteFile(filename,
+		[]byte(`{"local-minor": ["p2pool"]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := LoadPolicy(filename); err == nil {
+		t.Error("misspelt category accepted")
+	}
+	if _, found := lookupRole("local-minor"); found {
+		t.Error("misspelt category defined")
+	}
+}
+
+func TestLoadPolicyAcceptsContainerRoles(t *testing.T) {
+	e, docker := newFakeDockerExporter(t)
+	docker.roles["c4"] = "test-policy-wallet"
+
+	filename := filepath.Join(t.TempDir(), "policy.json")
+	err := os.WriteFile(filename,
+		[]byte(`{"test-policy-wallet": ["monero"]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e.PolicyFile = filename
+
+	if err := e.loadPolicy(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	wallet, _ := lookupRole("test-policy-wallet")
+	if e.Policy.Allows(PairHosts(wallet, ExternalHost)) {
+		t.Error("wallet allowed to talk to the internet")
+	}
+}
+
+func TestHandleAlertsOnDisallowedFlow(t *testing.T) {
+	quietLogs(t)
+
+	e := newTestExporter(nil)
+	e.Policy = DefaultPolicy()
+	key, _ := knownHostsKey(net.ParseIP("172.18.0.5"))
+	e.knowHost(key, LocalMiner)
+
+	var alerts []Alert
+	e.OnAlert = func(a Alert) { alerts = append(alerts, a) }
+	e.Reset()
+
+	allowed := newTCPPacket(t, "172.18.0.5", 40000, "172.18.0.3", 3333)
+	leak := newTCPPacket(t, "172.18.0.5", 40001, "203.0.113.7", 3333)
+	reply := newTCPPacket(t, "203.0.113.7", 3333, "172.18.0.5", 40001)
+	for _, packet := range []Packet{allowed, leak, leak, reply} {
+		if err := e.Handle(context.Background(), packet); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(alerts) != 1 {
+		t.Fatalf("got %d alerts, want 1", len(alerts))
+	}
+	want := Alert{
+		Time:     alerts[0].Time,
+		Flow:     PairHosts(LocalMiner, ExternalHost).String(),
+		Protocol: "tcp",
+		SrcAddr:  "172.18.0.5",
+		SrcPort:  40001,
+		DstAddr:  "203.0.113.7",
+		DstPort:  3333,
+	}
+	if alerts[0] != want {
+		t.Errorf("got %+v, want %+v", alerts[0], want)
+	}
+	if got := e.Alerts(); len(got) != 1 || got[0] != want {
+		t.Errorf("Alerts() = %+v", got)
+	}
+
+	window := e.Reset()
+	if got := window.Health.PolicyAlerts; got != 1 {
+		t.Errorf("got %d policy alerts, want 1", got)
+	}
+}
+
+func TestHandleAlertsOnUnlabelledMiner(t *testing.T) {
+	quietLogs(t)
+
+	e := newTestExporter(nil)
+	e.Policy = DefaultPolicy()
+	var alerts []Alert
+	e.OnAlert = func(a Alert) { alerts = append(alerts, a) }
+	e.Reset()
+
+	tests := []struct {
+		name      string
+		packet    Packet
+		wantAlert bool
+	}{
+		// Placed as a LocalMiner by its stratum peer port.
+		{"inferred", newTCPPacket(t, "172.18.0.99", 40000, "203.0.113.7", 3333), true},
+		// On the host's own address, with no procfs to place it.
+		{"host address", newTCPPacket(t, "192.0.2.50", 40001, "203.0.113.7", 3333), true},
+		{"reply", newTCPPacket(t, "203.0.113.8", 3333, "192.0.2.50", 40002), true},
+		{"to p2pool", newTCPPacket(t, "192.0.2.50", 40003, "172.18.0.3", 3333), false},
+		{"not stratum", newTCPPacket(t, "192.0.2.50", 40004, "203.0.113.7", 443), false},
+	}
+	for _, tt := range tests {
+		alerts = nil
+		if err := e.Handle(context.Background(), tt.packet); err != nil {
+			t.Fatal(err)
+		}
+		if got := len(alerts) != 0; got != tt.wantAlert {
+			t.Errorf("%s: got alert %v, want %v", tt.name, got, tt.wantAlert)
+		}
+	}
+}
+
+func TestAlertTableIsBounded(t *testing.T) {
+	var table alertTable
+	now := time.Date(2023, 12, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 3*maxAlerts; i++ {
+		a := Alert{
+			Time:    now,
+			SrcAddr: "172.18.0.5",
+			SrcPort: uint16(40000 + i),
+			DstAddr: "203.0.113.7",
+			DstPort: 3333,
+		}
+		if !table.raise(a) {
+			t.Fatalf("alert %d suppressed", i)
+		}
+	}
+	if len(table.recent) != maxAlerts || len(table.lastRaised) != maxAlerts {
+		t.Errorf("got %d alerts and %d connections, want %d of each",
+			len(table.recent), len(table.lastRaised), maxAlerts)
+	}
+
+	// Connections still remembered are still suppressed.
+	a := table.recent[len(table.recent)-1]
+	a.Time = now.Add(time.Minute)
+	if table.raise(a) {
+		t.Error("repeat alert raised")
+	}
+}
@@ -0,0 +1,385 @@
+package exporter
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	DefaultProcRoot = "/proc"
+
+	// Socket tables are reread at most this often, when a packet
+	// matches no known socket.
+	procScanInterval = time.Second
+)
+
+// ProcessAttributor attributes traffic to processes running outside
+// Docker, such as an xmrig started on the host or anything else
+// using host networking.  It matches each packet against the TCP
+// socket tables in Root/net, finds the process owning the matching
+// socket through Root/<pid>/fd, and categorizes it by its executable
+// name using NamedProcesses.  The host's own addresses are those
+// Root/net/fib_trie and Root/net/if_inet6 list, so a host's /proc
+// mounted into a container describes the host, not the container.
+//
+// Lookups never touch the filesystem: Run rescans in the background
+// whenever a lookup misses, at most once per procScanInterval, and
+// swaps in the new tables when it's done.  A new connection is
+// therefore only attributed once the rescan it triggered completes.
+type ProcessAttributor struct {
+	Root string
+
+	tables atomic.Pointer[procTables]
+	wake   chan struct{}
+
+	// Owned by the scanning goroutine.
+	owners map[uint64]Host
+}
+
+// procTables is one scan's worth of socket tables.  It's never
+// modified once published.
+type procTables struct {
+	sockets map[socketKey]uint64 // inode, by local and remote address
+	owners  map[uint64]Host      // by socket inode
+	local   map[netip.Addr]bool  // addresses of this host
+}
+
+type socketKey struct {
+	local, remote netip.AddrPort
+}
+
+func NewProcessAttributor(root string) *ProcessAttributor {
+	if root == "" {
+		root = DefaultProcRoot
+	}
+	return &ProcessAttributor{Root: root, wake: make(chan struct{}, 1)}
+}
+
+// Run rescans the socket tables on request until ctx is done.
+func (p *ProcessAttributor) Run(ctx Context) {
+	for {
+		if err := p.scan(); err != nil {
+			log.Println("warning:", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(procScanInterval):
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.wake:
+		}
+	}
+}
+
+// requestScan asks Run to rescan, without waiting.
+func (p *ProcessAttributor) requestScan() {
+	select {
+	case p.wake <- struct{}{}:
+	default:
+	}
+}
+
+// IsLocal reports whether ip is one of this host's addresses, as far
+// as the last scan knows.
+func (p *ProcessAttributor) IsLocal(ip net.IP) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	tables := p.tables.Load()
+	return tables != nil && tables.local[addr.Unmap()]
+}
+
+// Owner returns the host of the process whose socket connects
+// ip:port to peerIP:peerPort, or NilHost if this host's socket
+// tables don't know of one.
+func (p *ProcessAttributor) Owner(ip net.IP, port layers.TCPPort,
+	peerIP net.IP, peerPort layers.TCPPort) Host {
+
+	local, ok1 := addrPort(ip, port)
+	remote, ok2 := addrPort(peerIP, peerPort)
+	if !ok1 || !ok2 {
+		return NilHost
+	}
+
+	tables := p.tables.Load()
+	if tables == nil {
+		p.requestScan()
+		return NilHost
+	}
+	inode, found := tables.sockets[socketKey{local, remote}]
+	if !found {
+		p.requestScan()
+		return NilHost
+	}
+	return tables.owners[inode]
+}
+
+func addrPort(ip net.IP, port layers.TCPPort) (netip.AddrPort, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return netip.AddrPort{}, false
+	}
+	return netip.AddrPortFrom(addr.Unmap(), uint16(port)), true
+}
+
+// scan rereads the socket tables, finds the owners of any sockets it
+// hasn't seen before, and publishes the result.  Only one scan may
+// run at a time.
+func (p *ProcessAttributor) scan() error {
+	sockets := make(map[socketKey]uint64)
+	var errs []error
+	for _, name := range []string{"tcp", "tcp6"} {
+		err := readSocketTable(filepath.Join(p.Root, "net", name), sockets)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+
+	owners := make(map[uint64]Host, len(sockets))
+	unknown := make(map[uint64]bool)
+	local := make(map[netip.Addr]bool)
+	for key, inode := range sockets {
+		if addr := key.local.Addr(); !addr.IsUnspecified() {
+			local[addr] = true
+		}
+		if host, found := p.owners[inode]; found {
+			owners[inode] = host
+		} else {
+			unknown[inode] = true
+		}
+	}
+	if len(unknown) != 0 {
+		errs = append(errs, p.findOwners(unknown, owners))
+	}
+	for _, err := range []error{
+		readFibTrie(filepath.Join(p.Root, "net", "fib_trie"), local),
+		readIfInet6(filepath.Join(p.Root, "net", "if_inet6"), local),
+	} {
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+		}
+	}
+
+	p.owners = owners
+	p.tables.Store(&procTables{sockets, owners, local})
+	return errors.Join(errs...)
+}
+
+// readFibTrie adds the IPv4 addresses a /proc/net/fib_trie format
+// file lists as local to local.  Each address's line is followed by
+// one describing its routes, which includes "host LOCAL" for the
+// host's own addresses.
+func readFibTrie(filename string, local map[netip.Addr]bool) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var last netip.Addr
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if s, found := strings.CutPrefix(line, "|-- "); found {
+			last, _ = netip.ParseAddr(s)
+		} else if strings.HasSuffix(line, "host LOCAL") && last.IsValid() {
+			local[last] = true
+		}
+	}
+	return scanner.Err()
+}
+
+// readIfInet6 adds the IPv6 addresses listed in a /proc/net/if_inet6
+// format file to local.
+func readIfInet6(filename string, local map[netip.Addr]bool) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		b, err := hex.DecodeString(fields[0])
+		if err != nil || len(b) != 16 {
+			return fmt.Errorf("%s: bad address %q", filename, fields[0])
+		}
+		local[netip.AddrFrom16([16]byte(b))] = true
+	}
+	return scanner.Err()
+}
+
+// readSocketTable adds the sockets listed in a /proc/net/tcp or
+// /proc/net/tcp6 format file to sockets.
+func readSocketTable(filename string, sockets map[socketKey]uint64) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Scan() // header
+	for line := 2; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+
+		local, err := parseProcAddr(fields[1])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", filename, line, err)
+		}
+		remote, err := parseProcAddr(fields[2])
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", filename, line, err)
+		}
+		inode, err := strconv.ParseUint(fields[9], 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", filename, line, err)
+		}
+		if inode == 0 {
+			continue // TIME_WAIT and friends have no owner
+		}
+
+		sockets[socketKey{local, remote}] = inode
+	}
+	return scanner.Err()
+}
+
+// parseProcAddr parses an address like "0100007F:1F90".  The kernel
+// prints addresses as a sequence of 32-bit words in host byte order.
+func parseProcAddr(s string) (netip.AddrPort, error) {
+	addrHex, portHex, found := strings.Cut(s, ":")
+	if !found {
+		return netip.AddrPort{}, fmt.Errorf("%s: invalid address", s)
+	}
+
+	b, err := hex.DecodeString(addrHex)
+	if err != nil || (len(b) != 4 && len(b) != 16) {
+		return netip.AddrPort{}, fmt.Errorf("%s: invalid address", s)
+	}
+	for i := 0; i < len(b); i += 4 {
+		word := binary.BigEndian.Uint32(b[i:])
+		binary.NativeEndian.PutUint32(b[i:], word)
+	}
+	addr, _ := netip.AddrFromSlice(b)
+
+	port, err := strconv.ParseUint(portHex, 16, 16)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("%s: invalid port", s)
+	}
+
+	return netip.AddrPortFrom(addr.Unmap(), uint16(port)), nil
+}
+
+// findOwners walks every process's file descriptors looking for the
+// sockets in inodes, adding the owners it finds to owners.  Sockets
+// owned by processes not in NamedProcesses are added as NilHost, so
+// they aren't looked for again.
+func (p *ProcessAttributor) findOwners(inodes map[uint64]bool,
+	owners map[uint64]Host) error {
+
+	entries, err := os.ReadDir(p.Root)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		pid := entry.Name()
+		if _, err := strconv.Atoi(pid); err != nil {
+			continue
+		}
+
+		// Processes come and go, and some are unreadable unless
+		// we're root, so errors here are expected.
+		fdDir := filepath.Join(p.Root, pid, "fd")
+		fds, err := os.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+
+		var host Host
+		var named bool
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err != nil {
+				continue
+			}
+			inode, ok := socketInode(target)
+			if !ok || !inodes[inode] {
+				continue
+			}
+
+			if !named {
+				host = p.processHost(pid)
+				named = true
+			}
+			owners[inode] = host
+			delete(inodes, inode)
+		}
+		if len(inodes) == 0 {
+			break
+		}
+	}
+
+	for inode := range inodes {
+		owners[inode] = NilHost
+	}
+	return nil
+}
+
+// socketInode parses a file descriptor link like "socket:[12345]".
+func socketInode(target string) (uint64, bool) {
+	s, found := strings.CutPrefix(target, "socket:[")
+	if !found {
+		return 0, false
+	}
+	s, found = strings.CutSuffix(s, "]")
+	if !found {
+		return 0, false
+	}
+	inode, err := strconv.ParseUint(s, 10, 64)
+	return inode, err == nil
+}
+
+// processHost categorizes process pid by its executable's name, or
+// by its command name if the executable isn't readable.
+func (p *ProcessAttributor) processHost(pid string) Host {
+	var name string
+	if exe, err := os.Readlink(filepath.Join(p.Root, pid, "exe")); err == nil {
+		name = filepath.Base(strings.TrimSuffix(exe, " (deleted)"))
+	} else if comm, err := os.ReadFile(filepath.Join(p.Root, pid, "comm")); err == nil {
+		name = strings.TrimSpace(string(comm))
+	}
+
+	host, found := HostFromProcess(name)
+	if !found {
+		return NilHost
+	}
+	log.Printf("process %s (%s) => %s", pid, name, host)
+	return host
+}
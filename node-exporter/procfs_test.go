@@ -0,0 +1,320 @@
+package exporter
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/gopacket/layers"
+)
+
+// fakeProcfs builds a procfs tree in a temporary directory.
+type fakeProcfs struct {
+	t    *testing.T
+	root string
+	tcp  []string
+	tcp6 []string
+}
+
+func newFakeProcfs(t *testing.T) *fakeProcfs {
+	return &fakeProcfs{t: t, root: t.TempDir()}
+}
+
+// procAddr formats addr as the kernel does in /proc/net/tcp{,6}.
+func procAddr(addr netip.AddrPort) string {
+	b := addr.Addr().AsSlice()
+	var s strings.Builder
+	for i := 0; i < len(b); i += 4 {
+		fmt.Fprintf(&s, "%08X", binary.NativeEndian.Uint32(b[i:]))
+	}
+	fmt.Fprintf(&s, ":%04X", addr.Port())
+	return s.String()
+}
+
+// socket adds an established socket to the socket tables.
+func (f *fakeProcfs) socket(local, remote string, inode uint64) {
+	l := netip.MustParseAddrPort(local)
+	r := netip.MustParseAddrPort(remote)
+	line := fmt.Sprintf("   0: %s %s 01 00000000:00000000 00:00000000"+
+		" 00000000  1000        0 %d 1 0000000000000000 20 4 30 10 -1",
+		procAddr(l), procAddr(r), inode)
+	if l.Addr().Is4() {
+		f.tcp = append(f.tcp, line)
+	} else {
+		f.tcp6 = append(f.tcp6, line)
+	}
+	f.write()
+}
+
+// process adds a process with the given executable and sockets.
+func (f *fakeProcfs) process(pid int, exe string, inodes ...uint64) {
+	dir := filepath.Join(f.root, fmt.Sprint(pid))
+	if err := os.MkdirAll(filepath.Join(dir, "fd"), 0o755); err != nil {
+		f.t.Fatal(err)
+	}
+	if err := os.Symlink(exe, filepath.Join(dir, "exe")); err != nil {
+		f.t.Fatal(err)
+	}
+	for i, inode := range inodes {
+		target := fmt.Sprintf("socket:[%d]", inode)
+		link := filepath.Join(dir, "fd", fmt.Sprint(i+3))
+		if err := os.Symlink(target, link); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+}
+
+// localAddrs lists addrs as the host's own in net/fib_trie and
+// net/if_inet6, alongside a route that isn't.
+func (f *fakeProcfs) localAddrs(addrs ...string) {
+	var fib, inet6 strings.Builder
+	fib.WriteString("Main:\n  +-- 0.0.0.0/0 3 0 5\n" +
+		"     |-- 192.0.2.1\n        /32 link UNICAST\n")
+	for _, s := range addrs {
+		addr := netip.MustParseAddr(s)
+		if addr.Is4() {
+			fmt.Fprintf(&fib, "     |-- %s\n        /32 host LOCAL\n", addr)
+		} else {
+			b := addr.As16()
+			fmt.Fprintf(&inet6, "%x 02 40 00 80     eth0\n", b[:])
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(f.root, "net"), 0o755); err != nil {
+		f.t.Fatal(err)
+	}
+	for name, data := range map[string]string{
+		"fib_trie": fib.String(),
+		"if_inet6": inet6.String(),
+	} {
+		filename := filepath.Join(f.root, "net", name)
+		if err := os.WriteFile(filename, []byte(data), 0o644); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+}
+
+func (f *fakeProcfs) write() {
+	const header = "  sl  local_address rem_address   st tx_queue" +
+		" rx_queue tr tm->when retrnsmt   uid  timeout inode\n"
+
+	if err := os.MkdirAll(filepath.Join(f.root, "net"), 0o755); err != nil {
+		f.t.Fatal(err)
+	}
+	for name, lines := range map[string][]string{
+		"tcp":  f.tcp,
+		"tcp6": f.tcp6,
+	} {
+		data := header + strings.Join(lines, "\n") + "\n"
+		filename := filepath.Join(f.root, "net", name)
+		if err := os.WriteFile(filename, []byte(data), 0o644); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+}
+
+func TestParseProcAddr(t *testing.T) {
+	tests := []string{
+		"127.0.0.1:8080",
+		"172.18.0.1:3333",
+		"[2001:db8::1]:18080",
+		"[::ffff:10.0.0.1]:443",
+	}
+	for _, tt := range tests {
+		want := netip.MustParseAddrPort(tt)
+		got, err := parseProcAddr(procAddr(want))
+		if err != nil {
+			t.Errorf("%s: %v", tt, err)
+			continue
+		}
+		want = netip.AddrPortFrom(want.Addr().Unmap(), want.Port())
+		if got != want {
+			t.Errorf("got %v, want %v", got, want)
+		}
+	}
+
+	if binary.NativeEndian.Uint16([]byte{1, 0}) == 1 {
+		got, err := parseProcAddr("0100007F:1F90")
+		if err != nil || got.String() != "127.0.0.1:8080" {
+			t.Errorf("got %v, %v, want 127.0.0.1:8080", got, err)
+		}
+	}
+}
+
+func TestProcessAttributor(t *testing.T) {
+	quietLogs(t)
+
+	procfs := newFakeProcfs(t)
+	procfs.socket("172.18.0.1:40000", "172.18.0.3:3333", 5555)
+	procfs.socket("192.0.2.10:22", "198.51.100.1:50000", 6666)
+	procfs.socket("[2001:db8::10]:18080", "[2001:db8::99]:40001", 7777)
+	procfs.process(1234, "/usr/local/bin/xmrig", 5555)
+	procfs.process(99, "/usr/sbin/sshd", 6666)
+	procfs.process(4321, "/usr/bin/monerod", 7777)
+
+	p := NewProcessAttributor(procfs.root)
+	if err := p.scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name          string
+		local, remote string
+		want          Host
+	}{
+		{"miner", "172.18.0.1:40000", "172.18.0.3:3333", LocalMiner},
+		{"remote end", "172.18.0.3:3333", "172.18.0.1:40000", NilHost},
+		{"unnamed process", "192.0.2.10:22", "198.51.100.1:50000", NilHost},
+		{"IPv6", "[2001:db8::10]:18080", "[2001:db8::99]:40001", MoneroNode},
+		{"no socket", "172.18.0.9:40000", "172.18.0.3:3333", NilHost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			local := netip.MustParseAddrPort(tt.local)
+			remote := netip.MustParseAddrPort(tt.remote)
+			got := p.Owner(
+				net.IP(local.Addr().AsSlice()), layersPort(local),
+				net.IP(remote.Addr().AsSlice()), layersPort(remote))
+			if got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// New sockets are found once the tables are rescanned.
+	procfs.socket("172.18.0.1:40001", "172.18.0.3:3333", 8888)
+	procfs.process(1235, "/usr/local/bin/xmrig", 8888)
+	owner := func() Host {
+		return p.Owner(net.IPv4(172, 18, 0, 1), 40001,
+			net.IPv4(172, 18, 0, 3), 3333)
+	}
+	if got := owner(); got != NilHost {
+		t.Errorf("got %v before rescan, want %v", got, NilHost)
+	}
+	if len(p.wake) != 1 {
+		t.Error("miss didn't request a rescan")
+	}
+	if err := p.scan(); err != nil {
+		t.Fatal(err)
+	}
+	if got := owner(); got != LocalMiner {
+		t.Errorf("got %v after rescan, want %v", got, LocalMiner)
+	}
+}
+
+func TestProcessAttributorIsLocal(t *testing.T) {
+	procfs := newFakeProcfs(t)
+	procfs.socket("192.0.2.10:22", "198.51.100.1:50000", 6666)
+	procfs.localAddrs("192.0.2.20", "2001:db8::20")
+
+	p := NewProcessAttributor(procfs.root)
+	if err := p.scan(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"192.0.2.10", true},   // has a socket
+		{"192.0.2.20", true},   // in fib_trie
+		{"2001:db8::20", true}, // in if_inet6
+		{"198.51.100.1", false},
+		{"192.0.2.1", false}, // a route, not an address
+		{"127.0.0.1", false}, // this machine's, but not Root's
+	}
+	for _, tt := range tests {
+		if got := p.IsLocal(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.ip, got, tt.want)
+		}
+	}
+}
+
+func TestHandleAttributesHostProcesses(t *testing.T) {
+	quietLogs(t)
+
+	procfs := newFakeProcfs(t)
+	procfs.socket("172.18.0.1:40000", "172.18.0.3:3333", 5555)
+	procfs.process(1234, "/usr/local/bin/xmrig", 5555)
+
+	e := newTestExporter(nil)
+	e.Processes = NewProcessAttributor(procfs.root)
+	if err := e.Processes.scan(); err != nil {
+		t.Fatal(err)
+	}
+	e.Reset()
+
+	packet := newTCPPacket(t, "172.18.0.1", 40000, "172.18.0.3", 3333)
+	if err := e.Handle(context.Background(), packet); err != nil {
+		t.Fatal(err)
+	}
+
+	window := e.Reset()
+	flow := Flow{HostPair: PairHosts(LocalMiner, P2PoolNode), Port: DefaultStratumPort}
+	if window.Flows[flow] == nil {
+		t.Errorf("no %v flow in %v", flow, window.Flows)
+	}
+	if offenders := e.Offenders(); len(offenders) != 0 {
+		t.Errorf("got offenders %v, want none", offenders)
+	}
+}
+
+func TestHandleAttributesHostAddresses(t *testing.T) {
+	quietLogs(t)
+
+	// A host networked monerod on a global address would
+	// otherwise be categorized as the internet.
+	procfs := newFakeProcfs(t)
+	procfs.socket("192.0.2.10:18080", "203.0.113.7:40000", 7777)
+	procfs.process(4321, "/usr/bin/monerod", 7777)
+
+	e := newTestExporter(nil)
+	e.Processes = NewProcessAttributor(procfs.root)
+	if err := e.Processes.scan(); err != nil {
+		t.Fatal(err)
+	}
+	e.Reset()
+
+	packet := newTCPPacket(t, "203.0.113.7", 40000, "192.0.2.10", 18080)
+	if err := e.Handle(context.Background(), packet); err != nil {
+		t.Fatal(err)
+	}
+
+	window := e.Reset()
+	flow := Flow{HostPair: PairHosts(ExternalHost, MoneroNode), Port: 18080}
+	if window.Flows[flow] == nil {
+		t.Errorf("no %v flow in %v", flow, window.Flows)
+	}
+}
+
+func TestHandleSkipsProcfsForContainers(t *testing.T) {
+	quietLogs(t)
+
+	procfs := newFakeProcfs(t)
+	procfs.socket("172.18.0.1:40000", "172.18.0.3:3333", 5555)
+
+	e := newTestExporter(nil)
+	e.Processes = NewProcessAttributor(procfs.root)
+	if err := e.Processes.scan(); err != nil {
+		t.Fatal(err)
+	}
+	e.Reset()
+
+	packet := newTCPPacket(t, "172.18.0.3", 37889, "203.0.113.7", 40000)
+	if err := e.Handle(context.Background(), packet); err != nil {
+		t.Fatal(err)
+	}
+	if len(e.Processes.wake) != 0 {
+		t.Error("container traffic triggered a procfs rescan")
+	}
+}
+
+func layersPort(addr netip.AddrPort) layers.TCPPort {
+	return layers.TCPPort(addr.Port())
+}
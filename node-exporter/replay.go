@@ -0,0 +1,85 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/pcap"
+)
+
+// openOffline opens PacketFile for replay.  Both pcap and pcapng
+// files are accepted.
+func (e *Exporter) openOffline() (*capture, error) {
+	handle, err := pcap.OpenOffline(e.PacketFile)
+	if err != nil {
+		return nil, err
+	}
+	log.Println("reading from:", e.PacketFile)
+
+	return &capture{
+		handle: handle,
+		source: gopacket.NewPacketSource(handle, handle.LinkType()),
+	}, nil
+}
+
+// replayUntil drives the export windows from captured packet
+// timestamps rather than from the wall clock, exporting every
+// window that ends at or before t.
+func (e *Exporter) replayUntil(ctx Context, t time.Time) {
+	if t.IsZero() {
+		return
+	}
+	if e.lastReset.IsZero() {
+		e.resetAt(t)
+	}
+	if t.After(e.replayTime) {
+		e.replayTime = t
+	}
+
+	for limit := e.nextUploadTime(e.lastReset); !t.Before(limit); {
+		e.exportWindow(ctx, limit)
+		limit = e.nextUploadTime(e.lastReset)
+	}
+}
+
+// exportFinalWindow exports whatever was counted since the last
+// export, once the packet source is exhausted or we're stopping.
+func (e *Exporter) exportFinalWindow(ctx Context) {
+	limit := time.Now()
+	if e.ReplayTimestamps {
+		if e.lastReset.IsZero() {
+			return
+		}
+		limit = e.replayTime
+	}
+
+	e.exportWindow(ctx, limit)
+}
+
+// LoadDockerNetworks reads the output of "docker network inspect"
+// from a file, for categorizing replayed packets away from the host
+// they were captured on.
+func LoadDockerNetworks(filename string) ([]*DockerNetwork, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var networks []*DockerNetwork
+	if err := json.Unmarshal(data, &networks); err != nil {
+		network := &DockerNetwork{}
+		if json.Unmarshal(data, network) != nil {
+			return nil, err
+		}
+		return []*DockerNetwork{network}, nil
+	}
+	if len(networks) == 0 {
+		return nil, fmt.Errorf("%s: no networks", filename)
+	}
+
+	return networks, nil
+}
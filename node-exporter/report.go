@@ -0,0 +1,100 @@
+package exporter
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// A Report is the JSON representation of one export window.
+type Report struct {
+	Time     time.Time       `json:"time"`
+	Duration float64         `json:"duration"` // seconds
+	Flows    []FlowReport    `json:"flows"`
+	Stratum  []StratumReport `json:"stratum,omitempty"`
+	Levin    []LevinReport   `json:"levin,omitempty"`
+	Health   Health          `json:"health"`
+}
+
+type FlowReport struct {
+	Src       string `json:"src"`
+	Dst       string `json:"dst"`
+	Port      uint16 `json:"port"`
+	Interface string `json:"interface,omitempty"`
+	Counts
+}
+
+type StratumReport struct {
+	Worker string `json:"worker"`
+	StratumCounts
+}
+
+type LevinReport struct {
+	Src     string `json:"src"`
+	Dst     string `json:"dst"`
+	Command string `json:"command"`
+	LevinCounts
+}
+
+func NewReport(t time.Time, d time.Duration, w *Window) *Report {
+	r := &Report{
+		Time:     t,
+		Duration: d.Seconds(),
+		Flows:    make([]FlowReport, 0, len(w.Flows)),
+		Health:   w.Health,
+	}
+
+	for flow, counts := range w.Flows {
+		r.Flows = append(r.Flows, FlowReport{
+			Src:       flow.Src().String(),
+			Dst:       flow.Dst().String(),
+			Port:      uint16(flow.Port),
+			Interface: flow.Interface,
+			Counts:    *counts,
+		})
+	}
+	sort.Slice(r.Flows, func(i, j int) bool {
+		a, b := &r.Flows[i], &r.Flows[j]
+		if c := strings.Compare(a.Src, b.Src); c != 0 {
+			return c < 0
+		}
+		if c := strings.Compare(a.Dst, b.Dst); c != 0 {
+			return c < 0
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.Interface < b.Interface
+	})
+
+	for worker, counts := range w.Stratum {
+		r.Stratum = append(r.Stratum, StratumReport{
+			Worker:        worker,
+			StratumCounts: *counts,
+		})
+	}
+	sort.Slice(r.Stratum, func(i, j int) bool {
+		return r.Stratum[i].Worker < r.Stratum[j].Worker
+	})
+
+	for key, counts := range w.Levin {
+		r.Levin = append(r.Levin, LevinReport{
+			Src:         key.Src().String(),
+			Dst:         key.Dst().String(),
+			Command:     key.Command.String(),
+			LevinCounts: *counts,
+		})
+	}
+	sort.Slice(r.Levin, func(i, j int) bool {
+		a, b := &r.Levin[i], &r.Levin[j]
+		if c := strings.Compare(a.Src, b.Src); c != 0 {
+			return c < 0
+		}
+		if c := strings.Compare(a.Dst, b.Dst); c != 0 {
+			return c < 0
+		}
+		return a.Command < b.Command
+	})
+
+	return r
+}
@@ -0,0 +1,259 @@
+package exporter
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// A Sink receives each export window.
+type Sink interface {
+	Export(ctx Context, t time.Time, d time.Duration, w *Window) error
+}
+
+var httpClient = http.Client{Timeout: 30 * time.Second}
+
+const (
+	sinkQueueLength = 16
+	maxResponseSize = 1 << 20
+)
+
+// FanoutSink delivers each window to several sinks.  Every sink has
+// its own queue and goroutine, so a slow or failing sink delays
+// neither the others nor the caller.
+type FanoutSink struct {
+	// Blocking makes Export wait for queue space rather than
+	// dropping windows.  Use it when replaying, where nothing
+	// is lost by stalling packet handling.
+	Blocking bool
+
+	sinks  []Sink
+	queues []chan exportRequest
+	wg     sync.WaitGroup
+	once   sync.Once
+}
+
+type exportRequest struct {
+	ctx Context
+	t   time.Time
+	d   time.Duration
+	w   *Window
+}
+
+func NewFanoutSink(sinks ...Sink) *FanoutSink {
+	f := &FanoutSink{sinks: sinks}
+	for _, sink := range sinks {
+		queue := make(chan exportRequest, sinkQueueLength)
+		f.queues = append(f.queues, queue)
+
+		f.wg.Add(1)
+		go f.deliver(sink, queue)
+	}
+	return f
+}
+
+func (f *FanoutSink) deliver(sink Sink, queue <-chan exportRequest) {
+	defer f.wg.Done()
+
+	for r := range queue {
+		err := sink.Export(r.ctx, r.t, r.d, r.w)
+		if err != nil {
+			log.Printf("warning: %T: %v", sink, err)
+		}
+	}
+}
+
+func (f *FanoutSink) Export(ctx Context,
+	t time.Time, d time.Duration, w *Window) error {
+
+	r := exportRequest{context.WithoutCancel(ctx), t, d, w}
+
+	var errs []error
+	for i, queue := range f.queues {
+		if f.Blocking {
+			queue <- r
+			continue
+		}
+
+		select {
+		case queue <- r:
+		default:
+			errs = append(errs, fmt.Errorf(
+				"%T: queue full, dropped window", f.sinks[i]))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Close waits for all queued windows to be delivered, then closes
+// any sinks that implement io.Closer.
+func (f *FanoutSink) Close() error {
+	var errs []error
+	f.once.Do(func() {
+		for _, queue := range f.queues {
+			close(queue)
+		}
+		f.wg.Wait()
+
+		for _, sink := range f.sinks {
+			if c, ok := sink.(io.Closer); ok {
+				errs = append(errs, c.Close())
+			}
+		}
+	})
+	return errors.Join(errs...)
+}
+
+// JSONSink writes each window as a line of JSON.
+type JSONSink struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	closer io.Closer
+}
+
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{enc: json.NewEncoder(w)}
+}
+
+// OpenJSONSink returns a JSONSink that appends to filename, or that
+// writes to standard output if filename is "-".
+func OpenJSONSink(filename string) (*JSONSink, error) {
+	if filename == "-" {
+		return NewJSONSink(os.Stdout), nil
+	}
+
+	f, err := os.OpenFile(filename,
+		os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	s := NewJSONSink(f)
+	s.closer = f
+	return s, nil
+}
+
+func (s *JSONSink) Export(ctx Context,
+	t time.Time, d time.Duration, w *Window) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.enc.Encode(NewReport(t, d, w))
+}
+
+func (s *JSONSink) Close() error {
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
+
+type APIEndpoint struct {
+	URL         string `json:"url"`
+	AccessToken string `json:"access_token,omitempty"`
+
+	// AuthScheme is the scheme AccessToken is sent with,
+	// "Bearer" if empty.  With "Basic", AccessToken is
+	// "USER:PASSWORD", and is encoded as the scheme requires.
+	AuthScheme string `json:"auth_scheme,omitempty"`
+}
+
+// HTTPSink POSTs each window as a JSON document to Receiver.
+type HTTPSink struct {
+	Receiver APIEndpoint
+}
+
+func (s *HTTPSink) Export(ctx Context,
+	t time.Time, d time.Duration, w *Window) error {
+
+	_, err := s.Receiver.post(ctx, NewReport(t, d, w))
+	return err
+}
+
+// post POSTs v as a JSON document to the endpoint, returning the
+// response body.
+func (ep *APIEndpoint) post(ctx Context, v any) ([]byte, error) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return ep.postBody(ctx, "application/json", body)
+}
+
+// postBody POSTs body to the endpoint, returning the response body.
+func (ep *APIEndpoint) postBody(ctx Context, contentType string,
+	body []byte) ([]byte, error) {
+
+	req, err := http.NewRequestWithContext(ctx, "POST",
+		ep.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if ep.AccessToken != "" {
+		scheme := ep.AuthScheme
+		if scheme == "" {
+			scheme = "Bearer"
+		}
+		credentials := ep.AccessToken
+		if scheme == "Basic" {
+			credentials = base64.StdEncoding.EncodeToString(
+				[]byte(credentials))
+		}
+		req.Header.Set("Authorization", scheme+" "+credentials)
+	}
+
+	res, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	resBody, err := io.ReadAll(io.LimitReader(res.Body, maxResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return nil, fmt.Errorf("%s: %s", res.Request.URL, res.Status)
+	}
+	return resBody, nil
+}
+
+// writeDatagrams writes lines to conn, packing as many as fit into
+// each datagram of at most max bytes.  Lines longer than max are
+// sent on their own.
+func writeDatagrams(conn net.Conn, lines []string, max int) error {
+	var buf []byte
+	flush := func() error {
+		if len(buf) == 0 {
+			return nil
+		}
+		_, err := conn.Write(buf)
+		buf = buf[:0]
+		return err
+	}
+
+	for _, line := range lines {
+		if len(buf) != 0 && len(buf)+1+len(line) > max {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if len(buf) != 0 {
+			buf = append(buf, '\n')
+		}
+		buf = append(buf, line...)
+	}
+	return flush()
+}
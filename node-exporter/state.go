@@ -0,0 +1,167 @@
+package exporter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+const DefaultStateInterval = 5 * time.Minute
+
+// saveState writes the cumulative totals to StateFile, replacing
+// it atomically so a crash mid-write never loses the previous one.
+// Hosts are saved by name, since the numbers of dynamically defined
+// hosts vary between runs.
+func (e *Exporter) saveState() error {
+	data, err := json.Marshal(NewReport(time.Now(), 0, e.Totals()))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(e.StateFile),
+		filepath.Base(e.StateFile)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), e.StateFile); err != nil {
+		return err
+	}
+
+	e.lastCheckpoint = time.Now()
+	return nil
+}
+
+// checkpoint saves the state if StateInterval has passed since it
+// was last saved.
+func (e *Exporter) checkpoint() {
+	if e.StateFile == "" {
+		return
+	}
+
+	interval := e.StateInterval
+	if interval <= 0 {
+		interval = DefaultStateInterval
+	}
+	if time.Since(e.lastCheckpoint) < interval {
+		return
+	}
+
+	if err := e.saveState(); err != nil {
+		log.Println("warning:", err)
+	}
+}
+
+// loadState adds the totals saved in StateFile, if it exists, to
+// the exporter's totals.  Flows saved before they were counted per
+// interface have none; when capturing on a single device they're
+// assumed to have been seen on it, so their counters carry on.
+func (e *Exporter) loadState() error {
+	data, err := os.ReadFile(e.StateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	var r Report
+	if err := json.Unmarshal(data, &r); err != nil {
+		return fmt.Errorf("%s: %w", e.StateFile, err)
+	}
+
+	w, err := r.Window()
+	if err != nil {
+		log.Println("warning:", err)
+	}
+	if len(e.captures) == 1 && e.captures[0].device != "" {
+		w.assignInterface(e.captures[0].device)
+	}
+	e.addTotals(w)
+
+	log.Printf("restored totals saved at %v", r.Time)
+	e.lastCheckpoint = time.Now()
+	return nil
+}
+
+// assignInterface moves flows with no interface onto device.
+func (w *Window) assignInterface(device string) {
+	for flow, counts := range w.Flows {
+		if flow.Interface != "" {
+			continue
+		}
+		delete(w.Flows, flow)
+		flow.Interface = device
+		w.flowCounts(flow).Add(counts)
+	}
+}
+
+// Window is the inverse of NewReport.  Entries whose hosts or
+// commands can't be parsed are skipped, and reported in the error.
+func (r *Report) Window() (*Window, error) {
+	w := newWindow()
+	var errs []error
+
+	pair := func(src, dst string) (HostPair, error) {
+		srcHost, err := HostFromRole(src)
+		if err != nil {
+			return 0, err
+		}
+		dstHost, err := HostFromRole(dst)
+		if err != nil {
+			return 0, err
+		}
+		return PairHosts(srcHost, dstHost), nil
+	}
+
+	for i := range r.Flows {
+		f := &r.Flows[i]
+		hosts, err := pair(f.Src, f.Dst)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		flow := Flow{hosts, layers.TCPPort(f.Port), f.Interface}
+		w.flowCounts(flow).Add(&f.Counts)
+	}
+
+	for i := range r.Stratum {
+		s := &r.Stratum[i]
+		w.stratumCounts(s.Worker).Add(&s.StratumCounts)
+	}
+
+	for i := range r.Levin {
+		l := &r.Levin[i]
+		hosts, err := pair(l.Src, l.Dst)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		command, err := ParseLevinCommand(l.Command)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		w.levinCounts(LevinKey{hosts, command}).Add(&l.LevinCounts)
+	}
+
+	w.Health.Add(&r.Health)
+	return w, errors.Join(errs...)
+}
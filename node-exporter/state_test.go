@@ -0,0 +1,84 @@
+package exporter
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestStateRoundTrip(t *testing.T) {
+	quietLogs(t)
+
+	custom, err := HostFromRole("test-state-host")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := newWindow()
+	w.flowCounts(Flow{HostPair: PairHosts(P2PoolNode, ExternalHost), Port: 37889}).Bytes = 100
+	w.flowCounts(Flow{HostPair: PairHosts(custom, MoneroNode), Port: 18081}).Packets = 7
+	w.stratumCounts("rig1").Accepted = 3
+	w.levinCounts(LevinKey{PairHosts(MoneroNode, ExternalHost), 1002}).Messages = 5
+	w.Health.PacketsDropped = 2
+
+	state := filepath.Join(t.TempDir(), "state.json")
+	saved := &Exporter{StateFile: state}
+	saved.addTotals(w)
+	if err := saved.saveState(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Loading adds to any totals already counted.
+	restored := &Exporter{StateFile: state}
+	for i := 0; i < 2; i++ {
+		if err := restored.loadState(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := newWindow()
+	want.Add(w)
+	want.Add(w)
+	if got := restored.Totals(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
+
+func TestLoadStateWithoutFile(t *testing.T) {
+	e := &Exporter{StateFile: filepath.Join(t.TempDir(), "missing.json")}
+	if err := e.loadState(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadStateAssignsSoleInterface(t *testing.T) {
+	quietLogs(t)
+
+	flow := Flow{HostPair: PairHosts(P2PoolNode, ExternalHost), Port: 37889}
+	live := flow
+	live.Interface = "br-test"
+
+	// Totals saved before flows were counted per interface.
+	w := newWindow()
+	w.flowCounts(flow).Bytes = 100
+	state := filepath.Join(t.TempDir(), "state.json")
+	saved := &Exporter{StateFile: state}
+	saved.addTotals(w)
+	if err := saved.saveState(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := &Exporter{StateFile: state}
+	restored.captures = []*capture{{device: "br-test"}}
+	counted := newWindow()
+	counted.flowCounts(live).Bytes = 10
+	restored.addTotals(counted)
+	if err := restored.loadState(); err != nil {
+		t.Fatal(err)
+	}
+
+	totals := restored.Totals()
+	if len(totals.Flows) != 1 || totals.Flows[live].Bytes != 110 {
+		t.Errorf("got flows %v, want 110 bytes on br-test", totals.Flows)
+	}
+}
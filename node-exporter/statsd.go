@@ -0,0 +1,112 @@
+package exporter
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// StatsD has no standard way to tag metrics; these are the two
+// extensions agents most commonly understand.
+const (
+	// StatsDTagsDogStatsD appends tags as "|#key:value,...",
+	// as understood by Datadog and the Prometheus statsd_exporter.
+	StatsDTagsDogStatsD = "dogstatsd"
+
+	// StatsDTagsInflux appends tags to the metric name as
+	// ",key=value,...", as understood by Telegraf.
+	StatsDTagsInflux = "influx"
+)
+
+// StatsDSink sends each window's counts to a StatsD agent as
+// counters over UDP.  Counters are named "<measurement>.<field>",
+// for example "monero_node_flow.bytes", and counts of zero aren't
+// sent.
+type StatsDSink struct {
+	// TagFormat is StatsDTagsDogStatsD or StatsDTagsInflux,
+	// StatsDTagsDogStatsD if empty.
+	TagFormat string
+
+	conn net.Conn
+}
+
+// NewStatsDSink returns a StatsDSink sending to the agent at addr.
+func NewStatsDSink(addr, tagFormat string) (*StatsDSink, error) {
+	switch tagFormat {
+	case "", StatsDTagsDogStatsD, StatsDTagsInflux:
+	default:
+		return nil, fmt.Errorf("%s: unknown StatsD tag format", tagFormat)
+	}
+
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &StatsDSink{TagFormat: tagFormat, conn: conn}, nil
+}
+
+func (s *StatsDSink) Export(ctx Context,
+	t time.Time, d time.Duration, w *Window) error {
+
+	lines := s.lines(windowSeries(w))
+	if len(lines) == 0 {
+		return nil
+	}
+	return writeDatagrams(s.conn, lines, maxUDPPayload)
+}
+
+func (s *StatsDSink) Close() error {
+	return s.conn.Close()
+}
+
+var statsdEscaper = strings.NewReplacer(
+	",", "_", "=", "_", ":", "_", "|", "_", "#", "_", " ", "_", "\n", "_")
+
+func (s *StatsDSink) lines(ss []series) []string {
+	var lines []string
+	for _, sr := range ss {
+		var tags []string
+		for _, tag := range sr.tags {
+			if tag.value == "" {
+				continue
+			}
+			tags = append(tags, statsdEscaper.Replace(tag.key),
+				statsdEscaper.Replace(tag.value))
+		}
+
+		for _, field := range sr.fields {
+			if field.value == 0 {
+				continue
+			}
+			name := statsdEscaper.Replace(sr.measurement + "." + field.name)
+			value := strconv.FormatUint(field.value, 10)
+			lines = append(lines, s.line(name, value, tags))
+		}
+	}
+	return lines
+}
+
+// line formats one counter, with tags given as alternating keys and
+// values.
+func (s *StatsDSink) line(name, value string, tags []string) string {
+	var b strings.Builder
+	b.WriteString(name)
+	if s.TagFormat == StatsDTagsInflux {
+		for i := 0; i < len(tags); i += 2 {
+			fmt.Fprintf(&b, ",%s=%s", tags[i], tags[i+1])
+		}
+	}
+	fmt.Fprintf(&b, ":%s|c", value)
+	if s.TagFormat != StatsDTagsInflux && len(tags) != 0 {
+		b.WriteString("|#")
+		for i := 0; i < len(tags); i += 2 {
+			if i != 0 {
+				b.WriteString(",")
+			}
+			fmt.Fprintf(&b, "%s:%s", tags[i], tags[i+1])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,74 @@
+package exporter
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatsDSink(t *testing.T) {
+	for _, tc := range []struct {
+		format string
+		want   []string
+	}{
+		{StatsDTagsDogStatsD, []string{
+			"monero_node_flow.bytes:1500|c|#src:p2pool,dst:internet,port:37889,interface:br_0",
+			"monero_node_flow.packets:3|c|#src:p2pool,dst:internet,port:37889,interface:br_0",
+			"monero_node_exporter.packets_received:4|c",
+		}},
+		{StatsDTagsInflux, []string{
+			"monero_node_flow.bytes,src=p2pool,dst=internet,port=37889,interface=br_0:1500|c",
+			"monero_node_flow.packets,src=p2pool,dst=internet,port=37889,interface=br_0:3|c",
+			"monero_node_exporter.packets_received:4|c",
+		}},
+	} {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+
+		sink, err := NewStatsDSink(conn.LocalAddr().String(), tc.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sink.Close()
+
+		err = sink.Export(context.Background(), time.Now(), time.Minute,
+			newSeriesTestWindow())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got := readDatagram(t, conn)
+		if want := strings.Join(tc.want, "\n"); got != want {
+			t.Errorf("%s: got:\n%s\nwant:\n%s", tc.format, got, want)
+		}
+	}
+}
+
+func TestWriteDatagramsSplits(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	client, err := net.Dial("udp", conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	lines := []string{"aaaa", "bbbb", "cccc"}
+	if err := writeDatagrams(client, lines, 9); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"aaaa\nbbbb", "cccc"} {
+		if got := readDatagram(t, conn); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
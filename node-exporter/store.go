@@ -0,0 +1,495 @@
+package exporter
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	storeFileSuffix = ".jsonl"
+
+	// Queries use the finest rollup that covers their range in at
+	// most this many buckets.
+	maxQueryBuckets = 10000
+)
+
+// A Rollup is one resolution the Store keeps.  Its buckets are
+// appended to files in a subdirectory named for the rollup, one
+// file per segment, and segments older than Retention are deleted.
+type Rollup struct {
+	Name      string
+	Width     time.Duration
+	Retention time.Duration // zero keeps everything
+
+	segment string // time format naming each segment's file
+
+	bucket time.Time
+	counts map[storeKey]*Counts
+}
+
+// DefaultRollups returns the 1-minute, 1-hour and 1-day rollups
+// with their default retention.
+func DefaultRollups() []*Rollup {
+	return []*Rollup{
+		{Name: "1m", Width: time.Minute, Retention: 48 * time.Hour,
+			segment: "2006-01-02"},
+		{Name: "1h", Width: time.Hour, Retention: 90 * 24 * time.Hour,
+			segment: "2006-01"},
+		{Name: "1d", Width: 24 * time.Hour, segment: "2006"},
+	}
+}
+
+type storeKey struct {
+	src, dst string
+	port     uint16
+	iface    string
+}
+
+// A StoreRecord is one line of a rollup file: the counts for one
+// flow during the bucket starting at Time.
+type StoreRecord struct {
+	Time time.Time `json:"time"`
+	FlowReport
+}
+
+// Store is a Sink that keeps an on-disk, append-only history of
+// the flows in each window.  Hosts are stored by name, since the
+// numbers of dynamically defined hosts vary between runs.  Each
+// window is attributed to the bucket it started in.
+//
+// Every window is appended to the finest rollup, Rollups[0], as soon
+// as it's exported; queries add together the records of buckets
+// that span several windows.  Coarser rollups are only written once
+// their buckets are complete, and are derived from the finest rollup
+// until then: by queries, and by the next run after a crash or
+// restart.
+type Store struct {
+	Dir     string
+	Rollups []*Rollup
+
+	mu        sync.Mutex
+	recovered bool
+}
+
+// NewStore returns the Store in dir.  Nothing is created until the
+// first window is exported, so a Store may be queried read-only.
+func NewStore(dir string) (*Store, error) {
+	return &Store{Dir: dir, Rollups: DefaultRollups()}, nil
+}
+
+// Rollup returns the rollup called name, or nil if there isn't one.
+func (s *Store) Rollup(name string) *Rollup {
+	for _, r := range s.Rollups {
+		if r.Name == name {
+			return r
+		}
+	}
+	return nil
+}
+
+func (s *Store) rollupDir(r *Rollup) string {
+	return filepath.Join(s.Dir, r.Name)
+}
+
+func (s *Store) Export(ctx Context,
+	t time.Time, d time.Duration, w *Window) error {
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	start := t.Add(-d).UTC()
+
+	var errs []error
+	if !s.recovered {
+		errs = append(errs, s.recover(start))
+		s.recovered = true
+	}
+
+	fine := s.Rollups[0]
+	counts := make(map[storeKey]*Counts, len(w.Flows))
+	addFlows(counts, w)
+	errs = append(errs, s.write(fine, start.Truncate(fine.Width), counts))
+
+	for _, r := range s.Rollups[1:] {
+		bucket := start.Truncate(r.Width)
+		if !bucket.Equal(r.bucket) {
+			errs = append(errs, s.write(r, r.bucket, r.counts))
+			r.bucket = bucket
+			r.counts = nil
+		}
+
+		if r.counts == nil {
+			r.counts = make(map[storeKey]*Counts)
+		}
+		addFlows(r.counts, w)
+	}
+	return errors.Join(errs...)
+}
+
+func addFlows(counts map[storeKey]*Counts, w *Window) {
+	for flow, fc := range w.Flows {
+		key := storeKey{
+			src:   flow.Src().String(),
+			dst:   flow.Dst().String(),
+			port:  uint16(flow.Port),
+			iface: flow.Interface,
+		}
+		c := counts[key]
+		if c == nil {
+			c = &Counts{}
+			counts[key] = c
+		}
+		c.Add(fc)
+	}
+}
+
+func addRecord(counts map[storeKey]*Counts, rec *StoreRecord) {
+	key := storeKey{rec.Src, rec.Dst, rec.Port, rec.Interface}
+	c := counts[key]
+	if c == nil {
+		c = &Counts{}
+		counts[key] = c
+	}
+	c.Add(&rec.Counts)
+}
+
+// recover rebuilds each coarser rollup's buckets since the last one
+// written from the finest rollup, writing those that were complete
+// before now and keeping the current one in memory.  The caller must
+// hold s.mu.
+func (s *Store) recover(now time.Time) error {
+	fine := s.Rollups[0]
+
+	var errs []error
+	for _, r := range s.Rollups[1:] {
+		from, err := s.pending(r)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		buckets := make(map[time.Time]map[storeKey]*Counts)
+		to := now.Truncate(fine.Width).Add(fine.Width)
+		err = s.scan(fine, from, to, func(rec *StoreRecord) {
+			bucket := rec.Time.Truncate(r.Width)
+			if buckets[bucket] == nil {
+				buckets[bucket] = make(map[storeKey]*Counts)
+			}
+			addRecord(buckets[bucket], rec)
+		})
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		current := now.Truncate(r.Width)
+		times := make([]time.Time, 0, len(buckets))
+		for bucket := range buckets {
+			times = append(times, bucket)
+		}
+		sort.Slice(times, func(i, j int) bool {
+			return times[i].Before(times[j])
+		})
+		for _, bucket := range times {
+			if bucket.Equal(current) {
+				r.bucket = bucket
+				r.counts = buckets[bucket]
+				continue
+			}
+			errs = append(errs, s.write(r, bucket, buckets[bucket]))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// pending returns the start of the first of r's buckets that hasn't
+// been written, or the zero time if none have.
+func (s *Store) pending(r *Rollup) (time.Time, error) {
+	segments, err := s.segments(r)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	for i := len(segments) - 1; i >= 0; i-- {
+		var last time.Time
+		err := readSegment(segments[i].filename, func(rec *StoreRecord) {
+			if rec.Time.After(last) {
+				last = rec.Time
+			}
+		})
+		if err != nil {
+			return time.Time{}, err
+		}
+		if !last.IsZero() {
+			return last.Add(r.Width), nil
+		}
+	}
+	return time.Time{}, nil
+}
+
+// Close is a no-op: windows are written as they're exported, and
+// the next run rebuilds any incomplete coarser buckets.
+func (s *Store) Close() error {
+	return nil
+}
+
+// write appends counts, the bucket of r starting at bucket, to its
+// segment file.  The caller must hold s.mu.
+func (s *Store) write(r *Rollup, bucket time.Time,
+	counts map[storeKey]*Counts) error {
+
+	if len(counts) == 0 {
+		return nil
+	}
+
+	records := make([]StoreRecord, 0, len(counts))
+	for key, c := range counts {
+		records = append(records, StoreRecord{
+			Time: bucket,
+			FlowReport: FlowReport{
+				Src:       key.src,
+				Dst:       key.dst,
+				Port:      key.port,
+				Interface: key.iface,
+				Counts:    *c,
+			},
+		})
+	}
+	sort.Slice(records, func(i, j int) bool {
+		a, b := &records[i].FlowReport, &records[j].FlowReport
+		if a.Src != b.Src {
+			return a.Src < b.Src
+		}
+		if a.Dst != b.Dst {
+			return a.Dst < b.Dst
+		}
+		if a.Port != b.Port {
+			return a.Port < b.Port
+		}
+		return a.Interface < b.Interface
+	})
+
+	dir := s.rollupDir(r)
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return err
+	}
+	filename := filepath.Join(dir, bucket.Format(r.segment)+storeFileSuffix)
+	f, err := os.OpenFile(filename,
+		os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return err
+	}
+
+	b := bufio.NewWriter(f)
+	enc := json.NewEncoder(b)
+	for i := range records {
+		if err := enc.Encode(&records[i]); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := b.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return s.prune(r, bucket)
+}
+
+// prune deletes r's segments that ended before its retention period.
+func (s *Store) prune(r *Rollup, now time.Time) error {
+	if r.Retention <= 0 {
+		return nil
+	}
+	cutoff := now.Add(-r.Retention)
+
+	segments, err := s.segments(r)
+	if err != nil {
+		return err
+	}
+	for i, segment := range segments {
+		// A segment ends where the next begins.
+		if i+1 == len(segments) || !segments[i+1].start.Before(cutoff) {
+			break
+		}
+		if err := os.Remove(segment.filename); err != nil {
+			return err
+		}
+		log.Printf("%s: deleted", segment.filename)
+	}
+	return nil
+}
+
+type storeSegment struct {
+	filename string
+	start    time.Time
+}
+
+// segments returns r's segment files, oldest first.
+func (s *Store) segments(r *Rollup) ([]storeSegment, error) {
+	dir := s.rollupDir(r)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var segments []storeSegment
+	for _, entry := range entries {
+		name, found := strings.CutSuffix(entry.Name(), storeFileSuffix)
+		if !found {
+			continue
+		}
+		start, err := time.Parse(r.segment, name)
+		if err != nil {
+			continue
+		}
+		segments = append(segments, storeSegment{
+			filename: filepath.Join(dir, entry.Name()),
+			start:    start,
+		})
+	}
+	sort.Slice(segments, func(i, j int) bool {
+		return segments[i].start.Before(segments[j].start)
+	})
+	return segments, nil
+}
+
+// A PairTotal is the traffic from one host to another over a
+// query's time range, summed over all ports.
+type PairTotal struct {
+	Src string `json:"src"`
+	Dst string `json:"dst"`
+	Counts
+}
+
+// QueryRollup returns the finest rollup whose retention reaches
+// back to from, as of now, and that covers [from, to) in at most
+// maxQueryBuckets buckets.
+func (s *Store) QueryRollup(from, to, now time.Time) *Rollup {
+	for _, r := range s.Rollups {
+		if r.Retention > 0 && from.Before(now.Add(-r.Retention)) {
+			continue
+		}
+		if to.Sub(from) <= maxQueryBuckets*r.Width {
+			return r
+		}
+	}
+	return s.Rollups[len(s.Rollups)-1]
+}
+
+// Query returns the total traffic per pair of hosts in r's buckets
+// starting in [from, to), most bytes first.  Buckets of coarser
+// rollups that haven't been written yet are derived from the finest.
+func (s *Store) Query(r *Rollup, from, to time.Time) ([]PairTotal, error) {
+	totals := make(map[[2]string]*Counts)
+	add := func(rec *StoreRecord) {
+		key := [2]string{rec.Src, rec.Dst}
+		c := totals[key]
+		if c == nil {
+			c = &Counts{}
+			totals[key] = c
+		}
+		c.Add(&rec.Counts)
+	}
+
+	if err := s.scan(r, from, to, add); err != nil {
+		return nil, err
+	}
+
+	if fine := s.Rollups[0]; r != fine {
+		pending, err := s.pending(r)
+		if err != nil {
+			return nil, err
+		}
+		err = s.scan(fine, pending, to, func(rec *StoreRecord) {
+			if bucket := rec.Time.Truncate(r.Width); !bucket.Before(from) {
+				add(rec)
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	result := make([]PairTotal, 0, len(totals))
+	for key, counts := range totals {
+		result = append(result, PairTotal{key[0], key[1], *counts})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		a, b := &result[i], &result[j]
+		if a.Bytes != b.Bytes {
+			return a.Bytes > b.Bytes
+		}
+		if a.Src != b.Src {
+			return a.Src < b.Src
+		}
+		return a.Dst < b.Dst
+	})
+	return result, nil
+}
+
+// scan calls f for each of r's records whose bucket starts in
+// [from, to).
+func (s *Store) scan(r *Rollup, from, to time.Time,
+	f func(*StoreRecord)) error {
+
+	segments, err := s.segments(r)
+	if err != nil {
+		return err
+	}
+
+	for i, segment := range segments {
+		if !segment.start.Before(to) {
+			break
+		}
+		if i+1 < len(segments) && !segments[i+1].start.After(from) {
+			continue
+		}
+		if err := readSegment(segment.filename, func(rec *StoreRecord) {
+			if rec.Time.Before(from) || !rec.Time.Before(to) {
+				return
+			}
+			f(rec)
+		}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readSegment(filename string, f func(*StoreRecord)) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		var rec StoreRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// A crash may leave a partial final line.
+			log.Printf("warning: %s:%d: %v", filename, line, err)
+			continue
+		}
+		f(&rec)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+	return nil
+}
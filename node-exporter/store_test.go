@@ -0,0 +1,212 @@
+package exporter
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreRollsUpAndQueries(t *testing.T) {
+	quietLogs(t)
+
+	s, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	egress := Flow{HostPair: PairHosts(P2PoolNode, ExternalHost), Port: 37889}
+	ingress := Flow{HostPair: PairHosts(ExternalHost, P2PoolNode), Port: 37889}
+	start := time.Date(2023, 12, 1, 23, 58, 0, 0, time.UTC)
+
+	// Four one-minute windows, straddling midnight.
+	for i := 0; i < 4; i++ {
+		w := newWindow()
+		w.flowCounts(egress).Bytes = 1000
+		w.flowCounts(ingress).Bytes = 10
+		end := start.Add(time.Duration(i+1) * time.Minute)
+		if err := s.Export(context.Background(), end, time.Minute, w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		rollup   string
+		from, to time.Time
+		want     uint64 // egress bytes
+	}{
+		{"1m", start, start.Add(time.Hour), 4000},
+		{"1m", start.Add(time.Minute), start.Add(3 * time.Minute), 2000},
+		{"1h", start.Add(-time.Hour), start.Add(time.Hour), 4000},
+		{"1d", start.Add(2 * time.Minute), start.Add(24 * time.Hour), 2000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rollup, func(t *testing.T) {
+			totals, err := s.Query(s.Rollup(tt.rollup), tt.from, tt.to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(totals) != 2 {
+				t.Fatalf("got %d pairs, want 2", len(totals))
+			}
+			got := totals[0]
+			if got.Src != "p2pool" || got.Dst != "internet" ||
+				got.Bytes != tt.want {
+				t.Errorf("got %+v, want p2pool-internet %d bytes",
+					got, tt.want)
+			}
+		})
+	}
+
+	names, _ := filepath.Glob(filepath.Join(s.Dir, "1m", "*.jsonl"))
+	if len(names) != 2 {
+		t.Errorf("got 1m segments %v, want one per day", names)
+	}
+}
+
+func TestStorePrunesExpiredSegments(t *testing.T) {
+	quietLogs(t)
+
+	s, err := NewStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := s.Rollup("1m")
+	r.Retention = 24 * time.Hour
+
+	flow := Flow{HostPair: PairHosts(P2PoolNode, ExternalHost), Port: 37889}
+	day := time.Date(2023, 12, 1, 12, 0, 0, 0, time.UTC)
+	for i := 0; i < 4; i++ {
+		w := newWindow()
+		w.flowCounts(flow).Bytes = 1
+		end := day.AddDate(0, 0, i)
+		if err := s.Export(context.Background(), end, time.Minute, w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join(s.Dir, "1m"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	if len(names) != 2 || names[0] != "2023-12-03.jsonl" {
+		t.Errorf("got segments %v, want the last two days", names)
+	}
+}
+
+func TestQueryRollup(t *testing.T) {
+	s := &Store{Rollups: DefaultRollups()}
+	now := time.Now()
+
+	day := 24 * time.Hour
+	tests := []struct {
+		from, to time.Duration // ago
+		want     string
+	}{
+		{time.Hour, 0, "1m"},
+		{7 * day, 0, "1h"},
+		{7 * day, 6 * day, "1h"},
+		{365 * day, 0, "1d"},
+		{365 * day, 364 * day, "1d"},
+	}
+	for _, tt := range tests {
+		got := s.QueryRollup(now.Add(-tt.from), now.Add(-tt.to), now)
+		if got.Name != tt.want {
+			t.Errorf("%v to %v ago: got %s, want %s",
+				tt.from, tt.to, got.Name, tt.want)
+		}
+	}
+
+	// Longer retention doesn't mean scanning every minute of it.
+	s.Rollup("1m").Retention = 365 * day
+	if got := s.QueryRollup(now.Add(-30*day), now, now); got.Name != "1h" {
+		t.Errorf("month with a year of minutes: got %s, want 1h", got.Name)
+	}
+	if got := s.QueryRollup(now.Add(-30*day), now.Add(-29*day), now); got.Name != "1m" {
+		t.Errorf("a day a month ago: got %s, want 1m", got.Name)
+	}
+}
+
+func TestStoreRecoversAfterCrash(t *testing.T) {
+	quietLogs(t)
+
+	dir := t.TempDir()
+	flow := Flow{HostPair: PairHosts(P2PoolNode, ExternalHost), Port: 37889}
+	start := time.Date(2023, 12, 1, 10, 0, 0, 0, time.UTC)
+	export := func(s *Store, minute int) {
+		w := newWindow()
+		w.flowCounts(flow).Bytes = 100
+		end := start.Add(time.Duration(minute+1) * time.Minute)
+		if err := s.Export(context.Background(), end, time.Minute, w); err != nil {
+			t.Fatal(err)
+		}
+	}
+	query := func(s *Store, rollup string) uint64 {
+		day := start.Truncate(24 * time.Hour)
+		totals, err := s.Query(s.Rollup(rollup), day, day.Add(48*time.Hour))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(totals) != 1 {
+			t.Fatalf("%s: got %d pairs, want 1", rollup, len(totals))
+		}
+		return totals[0].Bytes
+	}
+
+	// Three windows, then a crash without Close.
+	s, _ := NewStore(dir)
+	for i := 0; i < 3; i++ {
+		export(s, i)
+	}
+
+	// A reader sees everything so far, at every resolution.
+	reader, _ := NewStore(dir)
+	for _, r := range reader.Rollups {
+		if got := query(reader, r.Name); got != 300 {
+			t.Errorf("%s: got %d bytes before restart, want 300", r.Name, got)
+		}
+	}
+
+	// The next run carries on in the next hour.
+	s, _ = NewStore(dir)
+	export(s, 60)
+	export(s, 61)
+	for _, r := range s.Rollups {
+		if got := query(s, r.Name); got != 500 {
+			t.Errorf("%s: got %d bytes after restart, want 500", r.Name, got)
+		}
+	}
+
+	totals, err := s.Query(s.Rollup("1h"), start, start.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(totals) != 1 || totals[0].Bytes != 300 {
+		t.Errorf("got %+v for the first hour, want 300 bytes", totals)
+	}
+}
+
+func TestStoreQueryCreatesNothing(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "store")
+	s, err := NewStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Query(s.Rollup("1h"), time.Time{}, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("query created %s", dir)
+	}
+}
@@ -0,0 +1,272 @@
+package exporter
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/tcpassembly"
+)
+
+// StratumCounts are the statistics accumulated for each miner.
+type StratumCounts struct {
+	Logins   uint64 `json:"logins"`
+	Jobs     uint64 `json:"jobs"`
+	Submits  uint64 `json:"submits"`
+	Accepted uint64 `json:"accepted"`
+	Rejected uint64 `json:"rejected"`
+}
+
+func (c *StratumCounts) Add(other *StratumCounts) {
+	c.Logins += other.Logins
+	c.Jobs += other.Jobs
+	c.Submits += other.Submits
+	c.Accepted += other.Accepted
+	c.Rejected += other.Rejected
+}
+
+const (
+	maxStratumLine         = 64 << 10
+	maxStratumPagesPerConn = 64
+)
+
+// Reassembled streams with missing data are flushed every
+// streamFlushInterval if they've been stalled for streamFlushAge.
+const (
+	streamFlushInterval = 30 * time.Second
+	streamFlushAge      = 2 * time.Minute
+)
+
+// stratumDecoder reassembles TCP streams to and from the stratum
+// port and parses the newline-delimited JSON-RPC inside them.
+type stratumDecoder struct {
+	server    gopacket.Endpoint
+	assembler *tcpassembly.Assembler
+	sessions  map[stratumKey]*stratumSession
+	counts    func(worker string) *StratumCounts
+	lastFlush time.Time
+}
+
+// stratumKey identifies a session by its client endpoint.
+type stratumKey struct {
+	ip, port gopacket.Endpoint
+}
+
+// stratumSession is the state shared by both directions of one
+// stratum connection.
+type stratumSession struct {
+	decoder *stratumDecoder
+	key     stratumKey
+	streams int
+	worker  string
+	pending map[string]string // request ID => method
+}
+
+type stratumStream struct {
+	session    *stratumSession
+	fromClient bool
+	buf        []byte
+	discarding bool
+}
+
+type stratumMessage struct {
+	ID     json.RawMessage `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+	Result json.RawMessage `json:"result"`
+	Error  json.RawMessage `json:"error"`
+}
+
+type stratumLoginParams struct {
+	Login string `json:"login"`
+	RigID string `json:"rigid"`
+}
+
+type stratumLoginResult struct {
+	Job json.RawMessage `json:"job"`
+}
+
+func newStratumDecoder(port layers.TCPPort,
+	counts func(string) *StratumCounts) *stratumDecoder {
+
+	d := &stratumDecoder{
+		server:   layers.NewTCPPortEndpoint(port),
+		sessions: make(map[stratumKey]*stratumSession),
+		counts:   counts,
+	}
+	d.assembler = tcpassembly.NewAssembler(tcpassembly.NewStreamPool(d))
+	d.assembler.MaxBufferedPagesPerConnection = maxStratumPagesPerConn
+	return d
+}
+
+func (d *stratumDecoder) Handle(packet Packet, tcp *layers.TCP) {
+	netLayer := packet.NetworkLayer()
+	if netLayer == nil {
+		return
+	}
+
+	t := packet.Metadata().Timestamp
+	if t.IsZero() {
+		t = time.Now()
+	}
+	d.assembler.AssembleWithTimestamp(netLayer.NetworkFlow(), tcp, t)
+
+	if t.Sub(d.lastFlush) > streamFlushInterval {
+		d.assembler.FlushOlderThan(t.Add(-streamFlushAge))
+		d.lastFlush = t
+	}
+}
+
+// New implements tcpassembly.StreamFactory.
+func (d *stratumDecoder) New(netFlow, tcpFlow gopacket.Flow) tcpassembly.Stream {
+	fromClient := tcpFlow.Dst() == d.server
+
+	key := stratumKey{netFlow.Src(), tcpFlow.Src()}
+	if !fromClient {
+		key = stratumKey{netFlow.Dst(), tcpFlow.Dst()}
+	}
+
+	session := d.sessions[key]
+	if session == nil {
+		session = &stratumSession{
+			decoder: d,
+			key:     key,
+			worker:  key.ip.String(),
+			pending: make(map[string]string),
+		}
+		d.sessions[key] = session
+	}
+	session.streams++
+
+	return &stratumStream{session: session, fromClient: fromClient}
+}
+
+// Reassembled implements tcpassembly.Stream.
+func (s *stratumStream) Reassembled(rs []tcpassembly.Reassembly) {
+	for _, r := range rs {
+		if r.Skip != 0 {
+			// Data was lost; resynchronize at the next newline.
+			s.buf = s.buf[:0]
+			s.discarding = true
+		}
+		s.buf = append(s.buf, r.Bytes...)
+
+		for {
+			i := bytes.IndexByte(s.buf, '\n')
+			if i < 0 {
+				break
+			}
+			line := s.buf[:i]
+			if s.discarding {
+				s.discarding = false
+			} else {
+				s.session.handleLine(line, s.fromClient)
+			}
+			s.buf = append(s.buf[:0], s.buf[i+1:]...)
+		}
+
+		if len(s.buf) > maxStratumLine {
+			s.buf = s.buf[:0]
+			s.discarding = true
+		}
+	}
+}
+
+// ReassemblyComplete implements tcpassembly.Stream.
+func (s *stratumStream) ReassemblyComplete() {
+	session := s.session
+	session.streams--
+	if session.streams > 0 {
+		return
+	}
+
+	d := session.decoder
+	if d.sessions[session.key] == session {
+		delete(d.sessions, session.key)
+	}
+}
+
+func (s *stratumSession) handleLine(line []byte, fromClient bool) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return
+	}
+
+	var msg stratumMessage
+	if err := json.Unmarshal(line, &msg); err != nil {
+		log.Printf("%s: stratum: %v", s.worker, err)
+		return
+	}
+
+	if fromClient {
+		s.handleRequest(&msg)
+	} else {
+		s.handleResponse(&msg)
+	}
+}
+
+func (s *stratumSession) handleRequest(msg *stratumMessage) {
+	switch msg.Method {
+	case "login":
+		var params stratumLoginParams
+		if err := json.Unmarshal(msg.Params, &params); err == nil {
+			if params.RigID != "" {
+				s.worker = params.RigID
+			} else if params.Login != "" {
+				s.worker = params.Login
+			}
+		}
+		s.counts().Logins++
+
+	case "submit":
+		s.counts().Submits++
+
+	default:
+		return
+	}
+
+	if len(msg.ID) != 0 {
+		s.pending[string(msg.ID)] = msg.Method
+	}
+}
+
+func (s *stratumSession) handleResponse(msg *stratumMessage) {
+	if msg.Method == "job" {
+		s.counts().Jobs++
+		return
+	}
+	if len(msg.ID) == 0 {
+		return
+	}
+
+	method, found := s.pending[string(msg.ID)]
+	if !found {
+		return
+	}
+	delete(s.pending, string(msg.ID))
+
+	failed := len(msg.Error) != 0 && string(msg.Error) != "null"
+
+	switch method {
+	case "login":
+		var result stratumLoginResult
+		err := json.Unmarshal(msg.Result, &result)
+		if !failed && err == nil && len(result.Job) != 0 {
+			s.counts().Jobs++
+		}
+
+	case "submit":
+		if failed {
+			s.counts().Rejected++
+		} else {
+			s.counts().Accepted++
+		}
+	}
+}
+
+func (s *stratumSession) counts() *StratumCounts {
+	return s.decoder.counts(s.worker)
+}
@@ -0,0 +1,81 @@
+package exporter
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestNextUploadTime(t *testing.T) {
+	lastReset := time.Date(2023, 12, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		frequency time.Duration
+		want      time.Time
+	}{
+		{"default", 0, lastReset.Add(DefaultExportFreq)},
+		{"negative", -time.Second, lastReset.Add(DefaultExportFreq)},
+		{"configured", 5 * time.Second, lastReset.Add(5 * time.Second)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := &Exporter{ExportFrequency: tt.frequency}
+			if got := e.nextUploadTime(lastReset); !got.Equal(tt.want) {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNextUploadWait(t *testing.T) {
+	e := &Exporter{ExportFrequency: time.Minute}
+
+	if got := e.nextUploadWait(time.Now().Add(-time.Hour)); got <= 0 {
+		t.Errorf("overdue export: got %v, want positive wait", got)
+	}
+	got := e.nextUploadWait(time.Now())
+	if got <= 0 || got > time.Minute {
+		t.Errorf("fresh window: got %v, want (0, 1m]", got)
+	}
+}
+
+func TestExportMetricsRotatesWindows(t *testing.T) {
+	quietLogs(t)
+
+	sink := &collectingSink{}
+	e := &Exporter{ExportFrequency: 10 * time.Millisecond, Sink: sink}
+	e.Reset()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	rotations := make(chan rotateRequest)
+	done := make(chan error)
+	go func() {
+		done <- e.exportMetrics(ctx, e.lastReset, rotations)
+	}()
+
+	var limits []time.Time
+	for len(limits) < 3 {
+		r := <-rotations
+		limits = append(limits, r.limit)
+		r.reply <- e.resetAt(r.limit)
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+
+	for i := 1; i < len(limits); i++ {
+		if gap := limits[i].Sub(limits[i-1]); gap < e.ExportFrequency {
+			t.Errorf("windows %d and %d only %v apart", i-1, i, gap)
+		}
+	}
+
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if len(sink.windows) < 2 {
+		t.Errorf("got %d windows exported, want at least 2",
+			len(sink.windows))
+	}
+}
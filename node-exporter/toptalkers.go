@@ -0,0 +1,191 @@
+package exporter
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/gopacket/layers"
+)
+
+const (
+	DefaultTopTalkers = 32
+
+	topTalkersLogInterval = 10 * time.Minute
+	topTalkersLogged      = 3 // per category
+)
+
+// A Talker is one external peer, identified by its address and the
+// flow's service port, as seen by one local host category.
+type Talker struct {
+	Addr     string    `json:"addr"`
+	Port     uint16    `json:"port"`
+	BytesIn  uint64    `json:"bytes_in"` // from the peer
+	BytesOut uint64    `json:"bytes_out"`
+	Packets  uint64    `json:"packets"`
+	LastSeen time.Time `json:"last_seen"`
+
+	// Bytes inherited from the talkers this one replaced in the
+	// table.  The talker's true total is at most its bytes in and
+	// out plus Error, which is what it's ranked by.
+	Error uint64 `json:"error"`
+}
+
+func (t *Talker) bytes() uint64 {
+	return t.BytesIn + t.BytesOut + t.Error
+}
+
+type talkerKey struct {
+	addr string // net.IP bytes
+	port layers.TCPPort
+}
+
+// talkerTable tracks the heaviest external talkers for each local
+// host category using the space-saving algorithm: once a category's
+// table is full, a new talker replaces the one with the fewest bytes
+// and inherits its count, so no heavy hitter is ever missed.
+type talkerTable struct {
+	mu     sync.Mutex
+	tables map[Host]map[talkerKey]*Talker
+}
+
+// count accounts for size bytes between local and the external peer
+// at ip, keeping at most capacity talkers for local.  The flow's
+// service port is port.
+func (t *talkerTable) count(capacity int, local Host, ip net.IP,
+	port layers.TCPPort, size int, inbound bool, now time.Time) {
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.tables == nil {
+		t.tables = make(map[Host]map[talkerKey]*Talker)
+	}
+	table := t.tables[local]
+	if table == nil {
+		table = make(map[talkerKey]*Talker)
+		t.tables[local] = table
+	}
+
+	key := talkerKey{string(ip), port}
+	talker := table[key]
+	if talker == nil {
+		talker = &Talker{Addr: ip.String(), Port: uint16(port)}
+		if len(table) >= capacity {
+			var minKey talkerKey
+			var min *Talker
+			for k, v := range table {
+				if min == nil || v.bytes() < min.bytes() {
+					minKey, min = k, v
+				}
+			}
+			delete(table, minKey)
+
+			talker.Error = min.bytes()
+		}
+		table[key] = talker
+	}
+
+	if inbound {
+		talker.BytesIn += uint64(size)
+	} else {
+		talker.BytesOut += uint64(size)
+	}
+	talker.Packets++
+	talker.LastSeen = now
+}
+
+// top returns a copy of every category's talkers, heaviest first,
+// indexed by category name.
+func (t *talkerTable) top() map[string][]Talker {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := make(map[string][]Talker, len(t.tables))
+	for local, table := range t.tables {
+		talkers := make([]Talker, 0, len(table))
+		for _, talker := range table {
+			talkers = append(talkers, *talker)
+		}
+		sort.Slice(talkers, func(i, j int) bool {
+			a, b := &talkers[i], &talkers[j]
+			if a.bytes() != b.bytes() {
+				return a.bytes() > b.bytes()
+			}
+			if a.Addr != b.Addr {
+				return a.Addr < b.Addr
+			}
+			return a.Port < b.Port
+		})
+		result[local.String()] = talkers
+	}
+	return result
+}
+
+// TopTalkers returns the heaviest external peers of each local host
+// category, heaviest first.
+func (e *Exporter) TopTalkers() map[string][]Talker {
+	return e.talkers.top()
+}
+
+func (e *Exporter) serveTopTalkers(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(e.TopTalkers()); err != nil {
+		log.Println("warning:", err)
+	}
+}
+
+// countTalker accounts for a packet between a local host and an
+// external peer in the top talkers table.
+func (e *Exporter) countTalker(src, dst Host, srcIP, dstIP net.IP,
+	port layers.TCPPort, size int) {
+
+	n := e.TopTalkerCount
+	switch {
+	case n <= 0:
+	case src == ExternalHost && dst != ExternalHost:
+		e.talkers.count(n, dst, srcIP, port, size, true, time.Now())
+	case dst == ExternalHost && src != ExternalHost:
+		e.talkers.count(n, src, dstIP, port, size, false, time.Now())
+	}
+}
+
+// logTopTalkers logs the heaviest few talkers of each category, at
+// most once every topTalkersLogInterval.
+func (e *Exporter) logTopTalkers() {
+	if e.TopTalkerCount <= 0 ||
+		time.Since(e.lastTopTalkersLog) < topTalkersLogInterval {
+		return
+	}
+	e.lastTopTalkersLog = time.Now()
+
+	top := e.TopTalkers()
+	categories := make([]string, 0, len(top))
+	for category := range top {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+
+	for _, category := range categories {
+		talkers := top[category]
+		if len(talkers) > topTalkersLogged {
+			talkers = talkers[:topTalkersLogged]
+		}
+
+		var b strings.Builder
+		for i, t := range talkers {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			fmt.Fprintf(&b, "%s port %d (%d in, %d out)",
+				t.Addr, t.Port, t.BytesIn, t.BytesOut)
+		}
+		log.Printf("top talkers: %s: %s", category, &b)
+	}
+}
@@ -0,0 +1,41 @@
+package exporter
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestTalkerTableKeepsHeavyHitter(t *testing.T) {
+	const capacity = 8
+	var table talkerTable
+	now := time.Now()
+
+	heavy := net.IPv4(203, 0, 113, 66).To4()
+	for i := 0; i < 1000; i++ {
+		// One small packet from each of many peers...
+		ip := net.IPv4(198, 51, byte(i>>8), byte(i)).To4()
+		table.count(capacity, MoneroNode, ip, 18080, 100, true, now)
+
+		// ...and a stream of larger ones from a single peer.
+		if i%10 == 0 {
+			table.count(capacity, MoneroNode, heavy, 18080, 1500,
+				true, now)
+		}
+	}
+	table.count(capacity, P2PoolNode, heavy, 37889, 10, false, now)
+
+	top := table.top()
+	monerod := top["monero"]
+	if len(monerod) != capacity {
+		t.Fatalf("got %d talkers, want %d", len(monerod), capacity)
+	}
+	if got := monerod[0]; got.Addr != heavy.String() || got.Port != 18080 {
+		t.Errorf("got heaviest %+v, want %s port 18080", got, heavy)
+	}
+
+	p2pool := top["p2pool"]
+	if len(p2pool) != 1 || p2pool[0].BytesOut != 10 {
+		t.Errorf("got p2pool talkers %+v", p2pool)
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 
 	"github.com/docker/docker/api/types"
-	"github.com/docker/docker/client"
+	"github.com/docker/docker/api/types/events"
 
 	"github.com/google/gopacket"
 )
@@ -12,9 +12,26 @@ import (
 type (
 	Context = context.Context
 
-	DockerClient  = client.Client
-	DockerNetwork = types.NetworkResource
+	DockerEndpoint = types.EndpointResource
+	DockerNetwork  = types.NetworkResource
 
-	Packet       = gopacket.Packet
-	PacketSource = gopacket.PacketSource
+	Packet = gopacket.Packet
 )
+
+// DockerClient is the subset of the Docker API the exporter uses.
+// It's satisfied by *client.Client.
+type DockerClient interface {
+	NetworkInspect(ctx Context, networkID string,
+		options types.NetworkInspectOptions) (DockerNetwork, error)
+	ContainerList(ctx Context,
+		options types.ContainerListOptions) ([]types.Container, error)
+	Events(ctx Context,
+		options types.EventsOptions) (<-chan events.Message, <-chan error)
+	Close() error
+}
+
+// PacketSource supplies packets to the exporter.  It's satisfied by
+// *gopacket.PacketSource.
+type PacketSource interface {
+	NextPacket() (Packet, error)
+}
@@ -0,0 +1,90 @@
+package exporter
+
+import "strings"
+
+// A Window holds everything counted during one export interval.
+// The exporter's cumulative totals are held in a Window too.
+type Window struct {
+	Flows   map[Flow]*Counts
+	Stratum map[string]*StratumCounts
+	Levin   map[LevinKey]*LevinCounts
+	Health  Health
+}
+
+func newWindow() *Window {
+	return &Window{
+		Flows:   make(map[Flow]*Counts),
+		Stratum: make(map[string]*StratumCounts),
+		Levin:   make(map[LevinKey]*LevinCounts),
+	}
+}
+
+func (w *Window) flowCounts(flow Flow) *Counts {
+	counts := w.Flows[flow]
+	if counts == nil {
+		counts = &Counts{}
+		w.Flows[flow] = counts
+	}
+	return counts
+}
+
+// Miners choose their own worker names, so only this many are
+// counted separately, each truncated to maxWorkerLen bytes.  Any
+// others are counted together as OtherWorkers, which keeps the
+// number of series every sink exports bounded.
+const (
+	maxWorkers   = 256
+	maxWorkerLen = 64
+)
+
+// OtherWorkers is the worker that stratum traffic is counted
+// against once maxWorkers workers have been seen.
+const OtherWorkers = "(other)"
+
+func (w *Window) stratumCounts(worker string) *StratumCounts {
+	if len(worker) > maxWorkerLen {
+		worker = strings.ToValidUTF8(worker[:maxWorkerLen], "")
+	}
+	counts := w.Stratum[worker]
+	if counts == nil {
+		if len(w.Stratum) >= maxWorkers {
+			worker = OtherWorkers
+			if counts = w.Stratum[worker]; counts != nil {
+				return counts
+			}
+		}
+		counts = &StratumCounts{}
+		w.Stratum[worker] = counts
+	}
+	return counts
+}
+
+func (w *Window) levinCounts(key LevinKey) *LevinCounts {
+	counts := w.Levin[key]
+	if counts == nil {
+		counts = &LevinCounts{}
+		w.Levin[key] = counts
+	}
+	return counts
+}
+
+// Add adds everything counted in other to w.
+func (w *Window) Add(other *Window) {
+	for flow, counts := range other.Flows {
+		w.flowCounts(flow).Add(counts)
+	}
+	for worker, counts := range other.Stratum {
+		w.stratumCounts(worker).Add(counts)
+	}
+	for key, counts := range other.Levin {
+		w.levinCounts(key).Add(counts)
+	}
+	w.Health.Add(&other.Health)
+}
+
+// Clone returns a deep copy of w.
+func (w *Window) Clone() *Window {
+	clone := newWindow()
+	clone.Add(w)
+	return clone
+}